@@ -1,28 +1,247 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 var (
-	BotToken             string
-	MongoDBURL           string
-	AppID                int
-	AppHash              string
-	AIStudioAPIKey       string
-	AllowedChatIDs       []int64
-	MaxMediaSize         int64
-	DefaultModel         string
-	ImageModel           string
-	HighImageModel       string
-	TelegraphAccessToken string
+	BotToken                string
+	MongoDBURL              string
+	AppID                   int
+	AppHash                 string
+	AIStudioAPIKey          string
+	AllowedChatIDs          []int64
+	AccessRequests          bool
+	MaxMediaSize            int64
+	DefaultModel            string
+	ImageModel              string
+	SearchModel             string
+	TranscribeModel         string
+	TranscribeLanguage      string
+	TranscribeChunkSeconds  int
+	TranscribeMaxMinutes    int
+	HighImageModel          string
+	TelegraphAccessToken    string
+	LongResponseMode        string
+	SandboxDriver           string
+	DockerSocket            string
+	SandboxImage            string
+	SandboxNetwork          bool
+	SandboxLimits           RunLimits
+	LanguageRunLimits       map[string]RunLimits
+	RoleRunLimits           map[string]RunLimits
+	LanguageImages          map[string]string
+	SandboxWorkspaceVolume  string
+	SandboxGeneratedVolume  string
+	WorkspaceRoot           string
+	WorkspaceQuotaMB        int
+	SendFileMaxMB           int
+	SendFileTypes           []string
+	AttachmentMaxMB         int
+	StorageJanitor          bool
+	StorageMaxAge           time.Duration
+	StorageBudgetMB         int
+	StorageSweepInterval    time.Duration
+	LLMProvider             string
+	OpenAIBaseURL           string
+	OpenAIAPIKey            string
+	EnabledModules          []string
+	DisabledModules         []string
+	OwnerIDs                []int64
+	CreatorUsername         string
+	DefaultPersona          string
+	StreamEditChars         int
+	StreamEditInterval      time.Duration
+	StreamGroupEditInterval time.Duration
+	UserTokenQuota          Quota
+	ChatTokenQuota          Quota
+	UserImageQuota          Quota
+	ChatImageQuota          Quota
+	UserRateLimits          map[string]RateLimit
+	ChatRateLimits          map[string]RateLimit
 )
 
+// Rate limit classes. Text covers every AI trigger; image and code cover the
+// matching tools.
+const (
+	RateText  = "text"
+	RateImage = "image"
+	RateCode  = "code"
+)
+
+// RateClasses lists the rate limit classes in display order.
+var RateClasses = []string{RateText, RateImage, RateCode}
+
+// MIME types send_file may upload. A trailing * matches any subtype.
+const defaultSendFileTypes = "image/*,audio/*,video/*,text/*,application/pdf,application/json,application/xml," +
+	"application/zip,application/gzip,application/x-tar,application/vnd.openxmlformats-officedocument.*," +
+	"application/vnd.oasis.opendocument.*,application/msword,application/vnd.ms-excel,application/vnd.ms-powerpoint"
+
+// Quota limits usage per UTC day and month. Zero means unlimited.
+type Quota struct {
+	Daily   int64
+	Monthly int64
+}
+
+// RateLimit allows Count requests per Per, in bursts of up to Count. A zero
+// Count disables the limit.
+type RateLimit struct {
+	Count int
+	Per   time.Duration
+}
+
+func (r RateLimit) String() string {
+	if r.Count <= 0 || r.Per <= 0 {
+		return "off"
+	}
+	// Drop the zero units time.Duration prints, so 1m0s reads 1m
+	per := r.Per.String()
+	if strings.HasSuffix(per, "m0s") {
+		per = strings.TrimSuffix(per, "0s")
+	}
+	if strings.HasSuffix(per, "h0m") {
+		per = strings.TrimSuffix(per, "0m")
+	}
+	return fmt.Sprintf("%d/%s", r.Count, per)
+}
+
+// ParseRateLimit reads limits written as "count/duration", e.g. "10/1m".
+// "off" and "0" disable the limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "off" || s == "0" {
+		return RateLimit{}, nil
+	}
+	count, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q must look like 10/1m", s)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return RateLimit{}, fmt.Errorf("invalid count in rate limit %q", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid period in rate limit %q", s)
+	}
+	return RateLimit{Count: n, Per: d}, nil
+}
+
+// RunLimits bound one run_code call. Zero fields are unset and fall back to
+// the broader setting.
+type RunLimits struct {
+	Timeout  time.Duration
+	MemoryMB int
+	CPUs     float64
+	Pids     int
+	// OutputKB caps how much of stdout and stderr each reaches the model.
+	OutputKB int
+}
+
+// Merge returns l with the fields set in o replacing its own.
+func (l RunLimits) Merge(o RunLimits) RunLimits {
+	if o.Timeout > 0 {
+		l.Timeout = o.Timeout
+	}
+	if o.MemoryMB > 0 {
+		l.MemoryMB = o.MemoryMB
+	}
+	if o.CPUs > 0 {
+		l.CPUs = o.CPUs
+	}
+	if o.Pids > 0 {
+		l.Pids = o.Pids
+	}
+	if o.OutputKB > 0 {
+		l.OutputKB = o.OutputKB
+	}
+	return l
+}
+
+// Cap returns l with the fields set in o lowering its own; unlike Merge it
+// never raises a limit.
+func (l RunLimits) Cap(o RunLimits) RunLimits {
+	if o.Timeout > 0 && (l.Timeout == 0 || o.Timeout < l.Timeout) {
+		l.Timeout = o.Timeout
+	}
+	if o.MemoryMB > 0 && (l.MemoryMB == 0 || o.MemoryMB < l.MemoryMB) {
+		l.MemoryMB = o.MemoryMB
+	}
+	if o.CPUs > 0 && (l.CPUs == 0 || o.CPUs < l.CPUs) {
+		l.CPUs = o.CPUs
+	}
+	if o.Pids > 0 && (l.Pids == 0 || o.Pids < l.Pids) {
+		l.Pids = o.Pids
+	}
+	if o.OutputKB > 0 && (l.OutputKB == 0 || o.OutputKB < l.OutputKB) {
+		l.OutputKB = o.OutputKB
+	}
+	return l
+}
+
+// ParseRunLimits reads limits written as comma-separated key=value pairs,
+// e.g. "timeout=60s,memory=1024,cpus=2,pids=256,output=32". Memory is in MB
+// and output in KB.
+func ParseRunLimits(s string) (RunLimits, error) {
+	var l RunLimits
+	for _, item := range splitList(s) {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return l, fmt.Errorf("run limit %q must look like key=value", item)
+		}
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "timeout":
+			l.Timeout, err = time.ParseDuration(strings.TrimSpace(value))
+		case "memory":
+			l.MemoryMB, err = strconv.Atoi(strings.TrimSpace(value))
+		case "cpus":
+			l.CPUs, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		case "pids":
+			l.Pids, err = strconv.Atoi(strings.TrimSpace(value))
+		case "output":
+			l.OutputKB, err = strconv.Atoi(strings.TrimSpace(value))
+		default:
+			return l, fmt.Errorf("unknown run limit %q", key)
+		}
+		if err != nil {
+			return l, fmt.Errorf("invalid run limit %q", item)
+		}
+	}
+	return l, nil
+}
+
+// Roles that run limits can be set for; they match the tool permission
+// levels.
+var runLimitRoles = map[string]bool{"user": true, "admin": true, "owner": true}
+
+// loadRunLimits reads SANDBOX_LIMITS_<NAME> variables. NAME is a role
+// (USER, ADMIN, OWNER) or a run_code language.
+func loadRunLimits() (languages, roles map[string]RunLimits) {
+	languages = make(map[string]RunLimits)
+	roles = make(map[string]RunLimits)
+	for name, value := range envMap("SANDBOX_LIMITS_") {
+		limits, err := ParseRunLimits(value)
+		if err != nil {
+			log.Printf("Ignoring SANDBOX_LIMITS_%s: %v", strings.ToUpper(name), err)
+			continue
+		}
+		if runLimitRoles[name] {
+			roles[name] = limits
+		} else {
+			languages[name] = limits
+		}
+	}
+	return languages, roles
+}
+
 func Load() {
 	_ = godotenv.Load()
 
@@ -51,11 +270,61 @@ func Load() {
 		log.Fatal("APP_HASH is required")
 	}
 
+	LLMProvider = strings.ToLower(os.Getenv("LLM_PROVIDER"))
+	if LLMProvider == "" {
+		LLMProvider = "gemini"
+	}
+
 	AIStudioAPIKey = os.Getenv("AISTUDIO_API_KEY")
-	if AIStudioAPIKey == "" {
+	if AIStudioAPIKey == "" && LLMProvider == "gemini" {
 		log.Fatal("AISTUDIO_API_KEY is required")
 	}
 
+	OpenAIBaseURL = strings.TrimRight(os.Getenv("OPENAI_BASE_URL"), "/")
+	if OpenAIBaseURL == "" {
+		OpenAIBaseURL = "https://api.openai.com/v1"
+	}
+	OpenAIAPIKey = os.Getenv("OPENAI_API_KEY")
+
+	for _, id := range splitList(os.Getenv("OWNER_IDS")) {
+		if idInt, err := strconv.ParseInt(id, 10, 64); err == nil {
+			OwnerIDs = append(OwnerIDs, idInt)
+		}
+	}
+
+	CreatorUsername = strings.TrimPrefix(os.Getenv("CREATOR_USERNAME"), "@")
+	if CreatorUsername == "" {
+		CreatorUsername = "s4tyendra"
+	}
+
+	DefaultPersona = os.Getenv("DEFAULT_PERSONA")
+	if DefaultPersona == "" {
+		DefaultPersona = "nitya"
+	}
+
+	StreamEditChars = envInt("STREAM_EDIT_CHARS", 200)
+	StreamEditInterval = time.Duration(envInt("STREAM_EDIT_INTERVAL_MS", 1500)) * time.Millisecond
+	StreamGroupEditInterval = time.Duration(envInt("STREAM_GROUP_EDIT_INTERVAL_MS", 3000)) * time.Millisecond
+
+	UserTokenQuota = Quota{envInt64("USER_DAILY_TOKENS"), envInt64("USER_MONTHLY_TOKENS")}
+	ChatTokenQuota = Quota{envInt64("CHAT_DAILY_TOKENS"), envInt64("CHAT_MONTHLY_TOKENS")}
+	UserImageQuota = Quota{envInt64("USER_DAILY_IMAGES"), envInt64("USER_MONTHLY_IMAGES")}
+	ChatImageQuota = Quota{envInt64("CHAT_DAILY_IMAGES"), envInt64("CHAT_MONTHLY_IMAGES")}
+
+	UserRateLimits = map[string]RateLimit{
+		RateText:  envRateLimit("RATE_LIMIT_USER_TEXT", RateLimit{10, time.Minute}),
+		RateImage: envRateLimit("RATE_LIMIT_USER_IMAGE", RateLimit{3, 10 * time.Minute}),
+		RateCode:  envRateLimit("RATE_LIMIT_USER_CODE", RateLimit{10, 5 * time.Minute}),
+	}
+	ChatRateLimits = map[string]RateLimit{
+		RateText:  envRateLimit("RATE_LIMIT_CHAT_TEXT", RateLimit{30, time.Minute}),
+		RateImage: envRateLimit("RATE_LIMIT_CHAT_IMAGE", RateLimit{10, 10 * time.Minute}),
+		RateCode:  envRateLimit("RATE_LIMIT_CHAT_CODE", RateLimit{30, 5 * time.Minute}),
+	}
+
+	EnabledModules = splitList(os.Getenv("ENABLED_MODULES"))
+	DisabledModules = splitList(os.Getenv("DISABLED_MODULES"))
+
 	allowedChatIDsStr := os.Getenv("ALLOWED_CHAT_IDS")
 	if allowedChatIDsStr != "" {
 		ids := strings.Split(allowedChatIDsStr, ",")
@@ -67,6 +336,8 @@ func Load() {
 		}
 	}
 
+	AccessRequests, _ = strconv.ParseBool(os.Getenv("ACCESS_REQUESTS"))
+
 	maxMediaSizeStr := os.Getenv("MAX_MEDIA_SIZE")
 	if maxMediaSizeStr != "" {
 		MaxMediaSize, _ = strconv.ParseInt(maxMediaSizeStr, 10, 64)
@@ -80,6 +351,16 @@ func Load() {
 		DefaultModel = "gemini-3.0-flash-preview"
 	}
 
+	SearchModel = os.Getenv("SEARCH_MODEL")
+	if SearchModel == "" {
+		SearchModel = DefaultModel
+	}
+
+	TranscribeModel = envString("TRANSCRIBE_MODEL", DefaultModel)
+	TranscribeLanguage = os.Getenv("TRANSCRIBE_LANGUAGE")
+	TranscribeChunkSeconds = envInt("TRANSCRIBE_CHUNK_SECONDS", 600)
+	TranscribeMaxMinutes = envInt("TRANSCRIBE_MAX_MINUTES", 60)
+
 	ImageModel = os.Getenv("IMAGE_MODEL")
 	if ImageModel == "" {
 		ImageModel = "gemini-2.5-flash-image"
@@ -91,4 +372,150 @@ func Load() {
 	}
 
 	TelegraphAccessToken = os.Getenv("TELEGRAPH_ACCESS_TOKEN")
+
+	SandboxDriver = envString("SANDBOX_DRIVER", "docker")
+	DockerSocket = envString("DOCKER_SOCKET", "/var/run/docker.sock")
+	SandboxImage = envString("SANDBOX_IMAGE", "zeno-code-runner")
+	// Off by default: model-written code may follow injected instructions
+	SandboxNetwork = envBool("SANDBOX_NETWORK", false)
+	SandboxLimits = RunLimits{
+		Timeout:  time.Duration(envInt("SANDBOX_TIMEOUT_SECONDS", 30)) * time.Second,
+		MemoryMB: envInt("SANDBOX_MEMORY_MB", 512),
+		CPUs:     envFloat("SANDBOX_CPUS", 1),
+		Pids:     envInt("SANDBOX_PIDS", 128),
+		OutputKB: envInt("SANDBOX_OUTPUT_KB", 16),
+	}
+	LanguageRunLimits, RoleRunLimits = loadRunLimits()
+	LanguageImages = envMap("SANDBOX_IMAGE_")
+	// Volume names or, for bind mounts and the local driver, host paths
+	SandboxWorkspaceVolume = envString("SANDBOX_WORKSPACE_VOLUME", "zeno_workspace")
+	SandboxGeneratedVolume = envString("SANDBOX_GENERATED_VOLUME", "zeno_generated")
+	// Where the bot itself sees the workspace volume
+	WorkspaceRoot = envString("WORKSPACE_ROOT", "/workspace")
+	WorkspaceQuotaMB = envInt("WORKSPACE_QUOTA_MB", 200)
+
+	SendFileMaxMB = envInt("SEND_FILE_MAX_MB", 50)
+	SendFileTypes = splitList(envString("SEND_FILE_TYPES", defaultSendFileTypes))
+	AttachmentMaxMB = envInt("ATTACHMENT_MAX_MB", 50)
+
+	StorageJanitor = envBool("STORAGE_JANITOR", true)
+	// 0 turns off age-based expiry and leaves only the size budget
+	StorageMaxAge = time.Duration(envCount("STORAGE_MAX_AGE_DAYS", 30)) * 24 * time.Hour
+	StorageBudgetMB = envInt("STORAGE_BUDGET_MB", 5120)
+	StorageSweepInterval = time.Duration(envInt("STORAGE_SWEEP_MINUTES", 60)) * time.Minute
+
+	LongResponseMode = strings.ToLower(os.Getenv("LONG_RESPONSE_MODE"))
+	switch LongResponseMode {
+	case "telegraph", "split", "file":
+	case "":
+		LongResponseMode = "telegraph"
+	default:
+		log.Printf("Unknown LONG_RESPONSE_MODE %q, using telegraph", LongResponseMode)
+		LongResponseMode = "telegraph"
+	}
+}
+
+func IsOwner(userID int64) bool {
+	for _, id := range OwnerIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ModuleEnabled reports whether a module should be loaded. ENABLED_MODULES,
+// when set, is an allowlist; DISABLED_MODULES always wins.
+func ModuleEnabled(name string) bool {
+	for _, n := range DisabledModules {
+		if strings.EqualFold(n, name) {
+			return false
+		}
+	}
+	if len(EnabledModules) == 0 {
+		return true
+	}
+	for _, n := range EnabledModules {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// envCount is envInt for settings where 0 is meaningful.
+func envCount(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+func envFloat(key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+func envBool(key string, def bool) bool {
+	if v, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt64(key string) int64 {
+	v, _ := strconv.ParseInt(os.Getenv(key), 10, 64)
+	return v
+}
+
+// envMap collects the variables starting with prefix, keyed by the rest of
+// the name in lower case.
+func envMap(prefix string) map[string]string {
+	out := make(map[string]string)
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "" && value != "" {
+			out[strings.ToLower(name)] = value
+		}
+	}
+	return out
+}
+
+func envRateLimit(key string, def RateLimit) RateLimit {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	limit, err := ParseRateLimit(v)
+	if err != nil {
+		log.Printf("Ignoring %s: %v", key, err)
+		return def
+	}
+	return limit
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
 }
@@ -0,0 +1,215 @@
+// Package fetch downloads web pages for the model and reduces them to
+// readable text. Requests to private, loopback and other internal addresses
+// are refused, including after redirects and DNS resolution.
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	ErrBlockedAddress     = errors.New("address is not allowed")
+	ErrUnsupportedContent = errors.New("unsupported content type")
+)
+
+// Result is a fetched document as text.
+type Result struct {
+	// URL is the final URL after redirects.
+	URL         string
+	Title       string
+	ContentType string
+	Text        string
+	// Truncated is set when the body was larger than MaxBytes and only the
+	// start was read.
+	Truncated bool
+}
+
+type Fetcher struct {
+	MaxBytes     int64
+	Timeout      time.Duration
+	MaxRedirects int
+	UserAgent    string
+	// AllowPrivate permits internal addresses. Only tests should set it.
+	AllowPrivate bool
+}
+
+func New() *Fetcher {
+	return &Fetcher{
+		MaxBytes:     5 * 1024 * 1024,
+		Timeout:      15 * time.Second,
+		MaxRedirects: 5,
+		UserAgent:    "Mozilla/5.0 (compatible; ZenoBot/1.0)",
+	}
+}
+
+// Fetch downloads rawURL and extracts its text. HTML, PDF and text types are
+// supported.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Result, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("only http and https URLs are supported")
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("URL has no host")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/pdf,text/plain;q=0.9,*/*;q=0.5")
+
+	resp, err := f.client().Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlockedAddress) {
+			return nil, ErrBlockedAddress
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	result := &Result{URL: resp.Request.URL.String()}
+	if int64(len(body)) > f.MaxBytes {
+		body = body[:f.MaxBytes]
+		result.Truncated = true
+	}
+
+	header := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(header)
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+		header = mediaType
+	}
+	result.ContentType = mediaType
+
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		r, err := charset.NewReader(bytes.NewReader(body), header)
+		if err != nil {
+			return nil, fmt.Errorf("decode page: %w", err)
+		}
+		result.Title, result.Text, err = extractHTML(r)
+		if err != nil {
+			return nil, err
+		}
+
+	case mediaType == "application/pdf":
+		result.Text = extractPDF(body)
+		if result.Text == "" {
+			return nil, fmt.Errorf("no extractable text in PDF")
+		}
+
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		r, err := charset.NewReader(bytes.NewReader(body), header)
+		if err != nil {
+			return nil, fmt.Errorf("decode text: %w", err)
+		}
+		text, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		result.Text = strings.TrimSpace(strings.ToValidUTF8(string(text), ""))
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContent, mediaType)
+	}
+
+	return result, nil
+}
+
+func (f *Fetcher) client() *http.Client {
+	dialer := &net.Dialer{Timeout: f.Timeout}
+	if !f.AllowPrivate {
+		// Checked on the resolved address so redirects and DNS tricks can't
+		// reach internal hosts
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !publicAddr(addr) {
+				return ErrBlockedAddress
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			// A proxy would do the dialing and bypass the address check
+			Proxy: nil,
+			// Each Fetch builds its own transport, so idle connections would
+			// never be reused and only leak
+			DisableKeepAlives:     true,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   f.Timeout,
+			ResponseHeaderTimeout: f.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > f.MaxRedirects {
+				return fmt.Errorf("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// Ranges that are neither private nor loopback in netip's terms but still
+// aren't the public internet.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// publicAddr reports whether addr is safe to connect to.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, p := range reservedPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,296 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Plain title</title>
+  <meta property="og:title" content="  The   Real Title ">
+  <script>var tracking = 1;</script>
+</head>
+<body>
+  <nav><a href="/">Home</a> <a href="/about">About</a></nav>
+  <article>
+    <h2>Section   heading</h2>
+    <p>First paragraph with <b>bold</b>
+       and a line that wraps.</p>
+    <ul><li>one</li><li>two</li></ul>
+    <pre>x := 1
+y := 2</pre>
+    <p hidden>secret</p>
+  </article>
+  <footer>Copyright</footer>
+</body>
+</html>`
+
+func TestExtractHTML(t *testing.T) {
+	title, text, err := extractHTML(strings.NewReader(testPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title != "The Real Title" {
+		t.Errorf("title = %q, want og:title", title)
+	}
+
+	want := "## Section heading\n\n" +
+		"First paragraph with bold and a line that wraps.\n\n" +
+		"- one\n- two\n\n" +
+		"```\nx := 1\ny := 2\n```"
+	if text != want {
+		t.Errorf("text =\n%s\nwant\n%s", text, want)
+	}
+}
+
+func TestExtractHTMLWithoutArticle(t *testing.T) {
+	_, text, err := extractHTML(strings.NewReader(`<title>T</title><body><div>Body text</div><nav>menu</nav></body>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Body text" {
+		t.Errorf("text = %q, want the body without navigation", text)
+	}
+}
+
+// testPDF builds a minimal PDF around uncompressed content streams.
+func testPDF(streams ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, s := range streams {
+		fmt.Fprintf(&b, "%d 0 obj\n<< /Length 0 >>\nstream\n%s\nendstream\nendobj\n", i+1, s)
+	}
+	b.WriteString("%%EOF\n")
+	return b.Bytes()
+}
+
+func flatePDF(content string) []byte {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write([]byte(content))
+	w.Close()
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n1 0 obj\n<< /Length 0 /Filter /FlateDecode >>\nstream\n")
+	b.Write(z.Bytes())
+	b.WriteString("\nendstream\nendobj\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestExtractPDF(t *testing.T) {
+	tests := []struct {
+		name string
+		pdf  []byte
+		want string
+	}{
+		{
+			name: "Tj",
+			pdf:  testPDF("BT /F1 12 Tf 72 700 Td (Hello, PDF) Tj ET"),
+			want: "Hello, PDF",
+		},
+		{
+			name: "escapes and nested parentheses",
+			pdf:  testPDF(`BT (a \(b\) \101 (nested)) Tj ET`),
+			want: "a (b) A (nested)",
+		},
+		{
+			name: "TJ kerning",
+			pdf:  testPDF("BT [(Hel) 20 (lo) -500 (world)] TJ ET"),
+			want: "Hello world",
+		},
+		{
+			name: "hex strings",
+			pdf:  testPDF("BT <48 69> Tj [<2122>] TJ ET"),
+			want: "Hi!\"",
+		},
+		{
+			name: "line moves",
+			pdf:  testPDF("BT (one) Tj 0 -14 Td (two) Tj T* (three) Tj ET"),
+			want: "one\ntwo\nthree",
+		},
+		{
+			name: "FlateDecode",
+			pdf:  flatePDF("BT (compressed text) Tj ET"),
+			want: "compressed text",
+		},
+		{
+			name: "other filters are skipped",
+			pdf:  []byte("1 0 obj\n<< /Filter /DCTDecode >>\nstream\nBT (image) Tj ET\nendstream\nendobj\n"),
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractPDF(tt.pdf); got != tt.want {
+				t.Errorf("extractPDF = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testFetcher can reach httptest servers on loopback.
+func testFetcher() *Fetcher {
+	f := New()
+	f.AllowPrivate = true
+	return f
+}
+
+func TestFetchHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, testPage)
+	}))
+	defer srv.Close()
+
+	res, err := testFetcher().Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ContentType != "text/html" || res.Title != "The Real Title" || res.Truncated {
+		t.Errorf("got %+v", res)
+	}
+	if !strings.Contains(res.Text, "First paragraph") {
+		t.Errorf("text = %q", res.Text)
+	}
+}
+
+func TestFetchTruncated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, strings.Repeat("a", 100))
+	}))
+	defer srv.Close()
+
+	f := testFetcher()
+	f.MaxBytes = 10
+	res, err := f.Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Truncated || res.Text != strings.Repeat("a", 10) {
+		t.Errorf("got truncated=%v text=%q", res.Truncated, res.Text)
+	}
+
+	f.MaxBytes = 100
+	res, err = f.Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Truncated {
+		t.Error("body of exactly MaxBytes was marked truncated")
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ftp", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/final", http.StatusFound)
+	})
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "done")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	if _, err := testFetcher().Fetch(context.Background(), srv.URL+"/ftp"); err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+		t.Errorf("redirect to ftp: err = %v", err)
+	}
+
+	res, err := testFetcher().Fetch(context.Background(), srv.URL+"/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.URL != srv.URL+"/final" || res.Text != "done" {
+		t.Errorf("got URL %q text %q", res.URL, res.Text)
+	}
+}
+
+func TestFetchClosesConnections(t *testing.T) {
+	var open atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			open.Add(-1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	for range 3 {
+		if _, err := testFetcher().Fetch(context.Background(), srv.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for open.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := open.Load(); n != 0 {
+		t.Errorf("%d connections left open after fetching", n)
+	}
+}
+
+func TestFetchRejectsSchemes(t *testing.T) {
+	for _, u := range []string{"file:///etc/passwd", "ftp://example.com", "gopher://x", "http://"} {
+		if _, err := New().Fetch(context.Background(), u); err == nil {
+			t.Errorf("Fetch(%q) succeeded", u)
+		}
+	}
+}
+
+func TestFetchBlocksPrivate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "internal")
+	}))
+	defer srv.Close()
+
+	if _, err := New().Fetch(context.Background(), srv.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("loopback fetch: err = %v, want ErrBlockedAddress", err)
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	tests := map[string]bool{
+		"8.8.8.8":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fc00::1":          false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+		"224.0.0.1":        false,
+	}
+	for s, want := range tests {
+		if got := publicAddr(netip.MustParseAddr(s)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package fetch
+
+import (
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements that never hold the page's content.
+var skipped = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Nav:      true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Button:   true,
+	atom.Select:   true,
+	atom.Dialog:   true,
+}
+
+// Elements that start a new line.
+var blocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Header: true, atom.Ul: true, atom.Ol: true,
+	atom.Table: true, atom.Blockquote: true, atom.Figure: true,
+	atom.Figcaption: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Hr: true, atom.Details: true, atom.Summary: true,
+}
+
+var headingLevel = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+var (
+	spaceRun = regexp.MustCompile(`[ \t\r\n\f\v\x{00a0}]+`)
+	blankRun = regexp.MustCompile(`\n{3,}`)
+)
+
+// extractHTML returns the page title and its main text. Headings become
+// Markdown headings and list items are bulleted. When the page has an
+// article or main element, only that is used.
+func extractHTML(r io.Reader) (title, text string, err error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	title = pageTitle(doc)
+
+	root := findElement(doc, atom.Article)
+	if root == nil {
+		root = findElement(doc, atom.Main)
+	}
+	if root == nil {
+		root = findElement(doc, atom.Body)
+	}
+	if root == nil {
+		root = doc
+	}
+
+	var sb strings.Builder
+	w := &textWriter{sb: &sb}
+	w.walk(root)
+
+	text = blankRun.ReplaceAllString(strings.TrimSpace(sb.String()), "\n\n")
+	return title, text, nil
+}
+
+func pageTitle(doc *html.Node) string {
+	// og:title is usually the cleaner of the two
+	var og, title string
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Meta:
+				if attr(n, "property") == "og:title" && og == "" {
+					og = attr(n, "content")
+				}
+			case atom.Title:
+				if title == "" && n.FirstChild != nil {
+					title = n.FirstChild.Data
+				}
+			case atom.Body:
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(doc)
+
+	if og != "" {
+		title = og
+	}
+	return strings.TrimSpace(spaceRun.ReplaceAllString(title, " "))
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+type textWriter struct {
+	sb *strings.Builder
+	// pending is the number of line breaks owed before the next text
+	pending int
+}
+
+// newline separates blocks with a blank line.
+func (w *textWriter) newline() {
+	w.pending = 2
+}
+
+// lineBreak starts a new line without a blank one, as between list items.
+func (w *textWriter) lineBreak() {
+	w.pending = max(w.pending, 1)
+}
+
+func (w *textWriter) write(s string) {
+	if s == "" {
+		return
+	}
+	if w.pending > 0 && w.sb.Len() > 0 {
+		w.sb.WriteString(strings.Repeat("\n", w.pending))
+		s = strings.TrimLeft(s, " ")
+	} else if cur := w.sb.String(); cur == "" || strings.HasSuffix(cur, " ") || strings.HasSuffix(cur, "\n") {
+		s = strings.TrimLeft(s, " ")
+	}
+	w.pending = 0
+	w.sb.WriteString(s)
+}
+
+func (w *textWriter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.write(spaceRun.ReplaceAllString(n.Data, " "))
+		return
+	case html.ElementNode:
+		if skipped[n.DataAtom] || hasAttr(n, "hidden") || attr(n, "aria-hidden") == "true" {
+			return
+		}
+	}
+
+	switch {
+	case headingLevel[n.DataAtom] > 0:
+		w.newline()
+		if text := collapsedText(n); text != "" {
+			w.write(strings.Repeat("#", headingLevel[n.DataAtom]) + " " + text)
+		}
+		w.newline()
+		return
+
+	case n.DataAtom == atom.Pre:
+		w.newline()
+		w.write("```\n" + strings.Trim(rawText(n), "\n") + "\n```")
+		w.newline()
+		return
+
+	case n.DataAtom == atom.Li:
+		w.lineBreak()
+		w.write("- ")
+
+	case n.DataAtom == atom.Br:
+		w.sb.WriteString("\n")
+		return
+
+	case n.DataAtom == atom.Tr:
+		w.lineBreak()
+
+	case n.DataAtom == atom.Td || n.DataAtom == atom.Th:
+		w.write(" | ")
+
+	case blocks[n.DataAtom]:
+		w.newline()
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c)
+	}
+
+	if n.DataAtom == atom.Li {
+		w.lineBreak()
+	} else if blocks[n.DataAtom] {
+		w.newline()
+	}
+}
+
+// collapsedText is an element's text on one line.
+func collapsedText(n *html.Node) string {
+	return strings.TrimSpace(spaceRun.ReplaceAllString(rawText(n), " "))
+}
+
+func rawText(n *html.Node) string {
+	var sb strings.Builder
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		if n.Type == html.ElementNode && skipped[n.DataAtom] {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(n)
+	return sb.String()
+}
@@ -0,0 +1,283 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Decompressed streams are capped so a crafted PDF can't exhaust memory.
+const maxPDFStreamBytes = 20 * 1024 * 1024
+
+// extractPDF pulls text from a PDF's content streams. It reads the strings
+// shown by the Tj, TJ, ' and " operators in uncompressed and Flate streams,
+// which covers most generated documents. Scanned PDFs and fonts with custom
+// encodings yield little or nothing.
+func extractPDF(data []byte) string {
+	var out strings.Builder
+	budget := int64(maxPDFStreamBytes)
+
+	for rest := data; ; {
+		start := bytes.Index(rest, []byte("stream"))
+		if start < 0 {
+			break
+		}
+		// Skip the "endstream" of a stream we already passed
+		if start >= 3 && string(rest[start-3:start]) == "end" {
+			rest = rest[start+len("stream"):]
+			continue
+		}
+
+		dict := rest[:start]
+		if i := bytes.LastIndex(dict, []byte("obj")); i >= 0 {
+			dict = dict[i:]
+		}
+
+		body := rest[start+len("stream"):]
+		body = bytes.TrimPrefix(body, []byte("\r"))
+		body = bytes.TrimPrefix(body, []byte("\n"))
+		end := bytes.Index(body, []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		content := body[:end]
+		rest = body[end+len("endstream"):]
+
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			r, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			// Truncated streams still yield what was decoded
+			decoded, _ := io.ReadAll(io.LimitReader(r, budget))
+			r.Close()
+			budget -= int64(len(decoded))
+			content = decoded
+		case bytes.Contains(dict, []byte("/Filter")):
+			// Images and other encodings hold no text we can read
+			continue
+		}
+
+		if bytes.Contains(content, []byte("BT")) {
+			pdfText(content, &out)
+		}
+		if budget <= 0 {
+			break
+		}
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// pdfText interprets the text operators of one content stream.
+func pdfText(content []byte, out *strings.Builder) {
+	var operands []any
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+
+		case c == '(':
+			s, n := pdfLiteral(content[i:])
+			operands = append(operands, s)
+			i += n
+
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, pdfHex(content[i+1:i+end]))
+			i += end + 1
+
+		case c == '[':
+			// TJ arrays mix strings with kerning; large gaps are spaces
+			var sb strings.Builder
+			i++
+			for i < len(content) && content[i] != ']' {
+				switch {
+				case content[i] == '(':
+					s, n := pdfLiteral(content[i:])
+					sb.WriteString(s)
+					i += n
+				case content[i] == '<':
+					end := bytes.IndexByte(content[i:], '>')
+					if end < 0 {
+						return
+					}
+					sb.WriteString(pdfHex(content[i+1 : i+end]))
+					i += end + 1
+				case isPDFNumber(content[i]):
+					j := i
+					for j < len(content) && isPDFNumber(content[j]) {
+						j++
+					}
+					if v, err := strconv.ParseFloat(string(content[i:j]), 64); err == nil && v < -200 {
+						sb.WriteByte(' ')
+					}
+					i = j
+				default:
+					i++
+				}
+			}
+			i++
+			operands = append(operands, sb.String())
+
+		case isPDFNumber(c):
+			j := i
+			for j < len(content) && isPDFNumber(content[j]) {
+				j++
+			}
+			v, _ := strconv.ParseFloat(string(content[i:j]), 64)
+			operands = append(operands, v)
+			i = j
+
+		case c == '/':
+			// Names are only operands of operators we ignore
+			i++
+			for i < len(content) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			operands = append(operands, nil)
+
+		case isPDFDelimiter(c):
+			i++
+
+		default:
+			j := i
+			for j < len(content) && !isPDFDelimiter(content[j]) {
+				j++
+			}
+			pdfOperator(string(content[i:j]), operands, out)
+			operands = operands[:0]
+			i = j
+		}
+	}
+}
+
+func pdfOperator(op string, operands []any, out *strings.Builder) {
+	lastString := func() string {
+		for k := len(operands) - 1; k >= 0; k-- {
+			if s, ok := operands[k].(string); ok {
+				return s
+			}
+		}
+		return ""
+	}
+
+	switch op {
+	case "Tj", "TJ":
+		out.WriteString(lastString())
+	case "'", "\"":
+		out.WriteString("\n")
+		out.WriteString(lastString())
+	case "T*", "ET":
+		out.WriteString("\n")
+	case "Td", "TD":
+		// Only a vertical move starts a new line
+		if len(operands) >= 2 {
+			if ty, ok := operands[len(operands)-1].(float64); ok && ty != 0 {
+				out.WriteString("\n")
+			}
+		}
+	}
+}
+
+// pdfLiteral reads a (string) with nested parentheses and escapes, and
+// returns it with the number of bytes consumed. Bytes are read as Latin-1,
+// which matches PDFDocEncoding for common text.
+func pdfLiteral(b []byte) (string, int) {
+	var sb strings.Builder
+	depth := 0
+	i := 0
+	for ; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c == '\\' && i+1 < len(b):
+			i++
+			switch e := b[i]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					j := i
+					for j < len(b) && j < i+3 && b[j] >= '0' && b[j] <= '7' {
+						j++
+					}
+					v, _ := strconv.ParseUint(string(b[i:j]), 8, 8)
+					sb.WriteRune(rune(v))
+					i = j - 1
+				} else {
+					sb.WriteRune(rune(e))
+				}
+			}
+		case c == '(':
+			if depth > 0 {
+				sb.WriteByte(c)
+			}
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return sb.String(), i + 1
+			}
+			sb.WriteByte(c)
+		default:
+			sb.WriteRune(rune(c))
+		}
+	}
+	return sb.String(), i
+}
+
+// pdfHex decodes a <hex> string. Two-byte glyph IDs from embedded fonts
+// decode to control characters and are dropped.
+func pdfHex(b []byte) string {
+	hex := strings.Join(strings.Fields(string(b)), "")
+	if len(hex)%2 == 1 {
+		hex += "0"
+	}
+	var sb strings.Builder
+	for i := 0; i+1 < len(hex); i += 2 {
+		v, err := strconv.ParseUint(hex[i:i+2], 16, 8)
+		if err != nil {
+			return ""
+		}
+		if v >= 0x20 {
+			sb.WriteRune(rune(v))
+		}
+	}
+	return sb.String()
+}
+
+func isPDFNumber(c byte) bool {
+	return c >= '0' && c <= '9' || c == '.' || c == '-' || c == '+'
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
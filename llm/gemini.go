@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+type Gemini struct {
+	client *genai.Client
+}
+
+func NewGemini(ctx context.Context, apiKey string) (*Gemini, error) {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:  apiKey,
+		Backend: genai.BackendGeminiAPI,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Gemini{client: client}, nil
+}
+
+func (g *Gemini) Name() string {
+	return "gemini"
+}
+
+func (g *Gemini) Generate(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	return g.client.Models.GenerateContent(ctx, model, contents, cfg)
+}
+
+func (g *Gemini) Stream(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return g.client.Models.GenerateContentStream(ctx, model, contents, cfg)
+}
+
+func (g *Gemini) CountTokens(ctx context.Context, model string, contents []*genai.Content) (int32, error) {
+	resp, err := g.client.Models.CountTokens(ctx, model, contents, nil)
+	if err != nil {
+		return 0, err
+	}
+	return resp.TotalTokens, nil
+}
+
+func (g *Gemini) GenerateImage(ctx context.Context, model string, req *ImageRequest) (*ImageResult, error) {
+	cfg := &genai.GenerateContentConfig{
+		ResponseModalities: []string{"IMAGE"},
+	}
+	if req.AspectRatio != "" || req.Size != "" {
+		cfg.ImageConfig = &genai.ImageConfig{
+			AspectRatio: req.AspectRatio,
+			ImageSize:   req.Size,
+		}
+	}
+
+	parts := make([]*genai.Part, 0, len(req.Inputs)+1)
+	for _, img := range req.Inputs {
+		parts = append(parts, genai.NewPartFromBytes(img.Data, img.MIMEType))
+	}
+	parts = append(parts, genai.NewPartFromText(req.Prompt))
+
+	resp, err := g.client.Models.GenerateContent(ctx, model, []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)}, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImageResult{Usage: resp.UsageMetadata}
+	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if part.InlineData != nil {
+				result.Images = append(result.Images, Image{
+					Data:     part.InlineData.Data,
+					MIMEType: part.InlineData.MIMEType,
+				})
+			}
+		}
+	}
+
+	if len(result.Images) == 0 {
+		return nil, fmt.Errorf("no image data in response")
+	}
+	return result, nil
+}
+
+func (g *Gemini) Transcribe(ctx context.Context, model string, req *TranscribeRequest) (*Transcript, error) {
+	parts := []*genai.Part{
+		genai.NewPartFromBytes(req.Audio, req.MIMEType),
+		genai.NewPartFromText(transcribePrompt(req.Language)),
+	}
+	cfg := &genai.GenerateContentConfig{Temperature: genai.Ptr[float32](0)}
+
+	resp, err := g.client.Models.GenerateContent(ctx, model, []*genai.Content{genai.NewContentFromParts(parts, genai.RoleUser)}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Transcript{Text: strings.TrimSpace(resp.Text()), Usage: resp.UsageMetadata}, nil
+}
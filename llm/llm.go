@@ -0,0 +1,85 @@
+// Package llm abstracts the model backends used by the bot. Contents, configs and
+// responses use the genai types as the common format; non-Gemini providers
+// translate to and from their own wire formats.
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+
+	"google.golang.org/genai"
+
+	"zeno/config"
+)
+
+var ErrUnsupported = errors.New("operation not supported by provider")
+
+type Provider interface {
+	Name() string
+	Generate(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error)
+	Stream(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error]
+	CountTokens(ctx context.Context, model string, contents []*genai.Content) (int32, error)
+	GenerateImage(ctx context.Context, model string, req *ImageRequest) (*ImageResult, error)
+	Transcribe(ctx context.Context, model string, req *TranscribeRequest) (*Transcript, error)
+}
+
+type ImageRequest struct {
+	Prompt      string
+	AspectRatio string // e.g. "16:9", empty for provider default
+	Size        string // e.g. "2K", empty for provider default
+	// Inputs are source images to edit or combine, following Prompt as
+	// the instruction.
+	Inputs []Image
+}
+
+type Image struct {
+	Data     []byte
+	MIMEType string
+}
+
+type ImageResult struct {
+	Images []Image
+	Usage  *genai.GenerateContentResponseUsageMetadata
+}
+
+type TranscribeRequest struct {
+	Audio    []byte
+	MIMEType string
+	// FileName is sent to providers that take an upload; its extension
+	// should match the audio format.
+	FileName string
+	// Language is a hint such as "te" or "Hindi", empty to detect it.
+	Language string
+}
+
+type Transcript struct {
+	Text  string
+	Usage *genai.GenerateContentResponseUsageMetadata
+}
+
+// transcribePrompt asks a multimodal model for a verbatim transcript. Mixed
+// language speech is common in the bot's chats, so each language keeps its
+// own script.
+func transcribePrompt(language string) string {
+	prompt := "Transcribe this audio word for word. Don't translate or summarize. " +
+		"Write each language in its own script (e.g. Telugu in Telugu script, Hindi in Devanagari, English in Latin letters) and keep switches between languages as spoken. " +
+		"Output only the transcript. If there is no speech, output [no speech]."
+	if language != "" {
+		prompt += fmt.Sprintf(" The speech is mostly in %s.", language)
+	}
+	return prompt
+}
+
+// New builds the provider selected by config.LLMProvider.
+func New(ctx context.Context) (Provider, error) {
+	switch config.LLMProvider {
+	case "gemini":
+		return NewGemini(ctx, config.AIStudioAPIKey)
+	case "openai":
+		return NewOpenAI(config.OpenAIBaseURL, config.OpenAIAPIKey), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", config.LLMProvider)
+	}
+}
@@ -0,0 +1,626 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// OpenAI talks to any server implementing the OpenAI chat completions and
+// image generation endpoints (OpenAI itself, llama.cpp, Ollama, vLLM, ...).
+type OpenAI struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+func NewOpenAI(baseURL, apiKey string) *OpenAI {
+	return &OpenAI{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		http:    &http.Client{},
+	}
+}
+
+func (o *OpenAI) Name() string {
+	return "openai"
+}
+
+type oaMessage struct {
+	Role       string       `json:"role"`
+	Content    any          `json:"content,omitempty"`
+	ToolCalls  []oaToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string       `json:"tool_call_id,omitempty"`
+}
+
+type oaContentPart struct {
+	Type     string      `json:"type"`
+	Text     string      `json:"text,omitempty"`
+	ImageURL *oaImageURL `json:"image_url,omitempty"`
+}
+
+type oaImageURL struct {
+	URL string `json:"url"`
+}
+
+type oaToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type oaTool struct {
+	Type     string         `json:"type"`
+	Function oaToolFunction `json:"function"`
+}
+
+type oaToolFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type oaChatRequest struct {
+	Model         string         `json:"model"`
+	Messages      []oaMessage    `json:"messages"`
+	Tools         []oaTool       `json:"tools,omitempty"`
+	Temperature   *float32       `json:"temperature,omitempty"`
+	TopP          *float32       `json:"top_p,omitempty"`
+	MaxTokens     int32          `json:"max_tokens,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions map[string]any `json:"stream_options,omitempty"`
+}
+
+type oaUsage struct {
+	PromptTokens            int32 `json:"prompt_tokens"`
+	CompletionTokens        int32 `json:"completion_tokens"`
+	TotalTokens             int32 `json:"total_tokens"`
+	CompletionTokensDetails struct {
+		ReasoningTokens int32 `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+}
+
+type oaChoice struct {
+	Message      oaMessage `json:"message"`
+	Delta        oaMessage `json:"delta"`
+	FinishReason string    `json:"finish_reason"`
+}
+
+type oaChatResponse struct {
+	Choices []oaChoice `json:"choices"`
+	Usage   *oaUsage   `json:"usage"`
+}
+
+func (o *OpenAI) Generate(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	req, err := buildChatRequest(model, contents, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := o.post(ctx, "/chat/completions", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result oaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	out := &genai.GenerateContentResponse{UsageMetadata: convertUsage(result.Usage)}
+	if len(result.Choices) > 0 {
+		choice := result.Choices[0]
+		parts, err := messageToParts(choice.Message.Content, choice.Message.ToolCalls)
+		if err != nil {
+			return nil, err
+		}
+		out.Candidates = []*genai.Candidate{{
+			Content:      &genai.Content{Role: genai.RoleModel, Parts: parts},
+			FinishReason: convertFinishReason(choice.FinishReason),
+		}}
+	}
+	return out, nil
+}
+
+func (o *OpenAI) Stream(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) iter.Seq2[*genai.GenerateContentResponse, error] {
+	return func(yield func(*genai.GenerateContentResponse, error) bool) {
+		req, err := buildChatRequest(model, contents, cfg)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		req.Stream = true
+		req.StreamOptions = map[string]any{"include_usage": true}
+
+		resp, err := o.post(ctx, "/chat/completions", req)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		// Tool call arguments arrive in fragments keyed by index; they are
+		// assembled and emitted as one final chunk.
+		var calls []*oaToolCall
+		var usage *oaUsage
+		finish := ""
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "[DONE]" {
+				break
+			}
+
+			var chunk oaChatResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				yield(nil, fmt.Errorf("decode stream chunk: %w", err))
+				return
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			if len(chunk.Choices) == 0 {
+				continue
+			}
+
+			delta := chunk.Choices[0].Delta
+			if chunk.Choices[0].FinishReason != "" {
+				finish = chunk.Choices[0].FinishReason
+			}
+			for _, tc := range delta.ToolCalls {
+				for len(calls) <= tc.Index {
+					calls = append(calls, &oaToolCall{})
+				}
+				call := calls[tc.Index]
+				if tc.ID != "" {
+					call.ID = tc.ID
+				}
+				if tc.Function.Name != "" {
+					call.Function.Name = tc.Function.Name
+				}
+				call.Function.Arguments += tc.Function.Arguments
+			}
+			if text, _ := delta.Content.(string); text != "" {
+				if !yield(&genai.GenerateContentResponse{
+					Candidates: []*genai.Candidate{{
+						Content: &genai.Content{Role: genai.RoleModel, Parts: []*genai.Part{{Text: text}}},
+					}},
+				}, nil) {
+					return
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(nil, err)
+			return
+		}
+
+		toolCalls := make([]oaToolCall, 0, len(calls))
+		for _, c := range calls {
+			toolCalls = append(toolCalls, *c)
+		}
+		parts, err := messageToParts(nil, toolCalls)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		yield(&genai.GenerateContentResponse{
+			Candidates: []*genai.Candidate{{
+				Content:      &genai.Content{Role: genai.RoleModel, Parts: parts},
+				FinishReason: convertFinishReason(finish),
+			}},
+			UsageMetadata: convertUsage(usage),
+		}, nil)
+	}
+}
+
+// CountTokens has no standard OpenAI endpoint, so it estimates roughly four
+// characters per token.
+func (o *OpenAI) CountTokens(ctx context.Context, model string, contents []*genai.Content) (int32, error) {
+	chars := 0
+	for _, content := range contents {
+		for _, part := range content.Parts {
+			chars += len(part.Text)
+			if part.FunctionCall != nil {
+				args, _ := json.Marshal(part.FunctionCall.Args)
+				chars += len(args)
+			}
+			if part.FunctionResponse != nil {
+				response, _ := json.Marshal(part.FunctionResponse.Response)
+				chars += len(response)
+			}
+		}
+	}
+	return int32(chars/4 + 1), nil
+}
+
+func (o *OpenAI) GenerateImage(ctx context.Context, model string, req *ImageRequest) (*ImageResult, error) {
+	var resp *http.Response
+	var err error
+	if len(req.Inputs) > 0 {
+		resp, err = o.editImage(ctx, model, req)
+	} else {
+		body := map[string]any{
+			"model":           model,
+			"prompt":          req.Prompt,
+			"n":               1,
+			"response_format": "b64_json",
+		}
+		if size := openAIImageSize(req.AspectRatio); size != "" {
+			body["size"] = size
+		}
+		resp, err = o.post(ctx, "/images/generations", body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data []struct {
+			B64JSON string `json:"b64_json"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	out := &ImageResult{}
+	for _, d := range result.Data {
+		data, err := base64.StdEncoding.DecodeString(d.B64JSON)
+		if err != nil {
+			return nil, err
+		}
+		out.Images = append(out.Images, Image{Data: data, MIMEType: http.DetectContentType(data)})
+	}
+	if len(out.Images) == 0 {
+		return nil, fmt.Errorf("no image data in response")
+	}
+	return out, nil
+}
+
+// editImage sends source images to the edits endpoint, which takes a
+// multipart form instead of JSON.
+func (o *OpenAI) editImage(ctx context.Context, model string, req *ImageRequest) (*http.Response, error) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	form.WriteField("model", model)
+	form.WriteField("prompt", req.Prompt)
+	form.WriteField("n", "1")
+	form.WriteField("response_format", "b64_json")
+	if size := openAIImageSize(req.AspectRatio); size != "" {
+		form.WriteField("size", size)
+	}
+
+	field := "image"
+	if len(req.Inputs) > 1 {
+		field = "image[]"
+	}
+	for i, img := range req.Inputs {
+		ext := ".png"
+		if exts, _ := mime.ExtensionsByType(img.MIMEType); len(exts) > 0 {
+			ext = exts[0]
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename="image%d%s"`, field, i, ext))
+		header.Set("Content-Type", img.MIMEType)
+		w, err := form.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(img.Data)
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+	return o.send(ctx, "/images/edits", form.FormDataContentType(), &buf)
+}
+
+// Transcribe uses the Whisper-style transcriptions endpoint, which takes the
+// audio as a multipart upload and only understands ISO 639-1 language codes.
+func (o *OpenAI) Transcribe(ctx context.Context, model string, req *TranscribeRequest) (*Transcript, error) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	form.WriteField("model", model)
+	form.WriteField("response_format", "json")
+	if len(req.Language) == 2 {
+		form.WriteField("language", strings.ToLower(req.Language))
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, req.FileName))
+	header.Set("Content-Type", req.MIMEType)
+	w, err := form.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	w.Write(req.Audio)
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	resp, err := o.send(ctx, "/audio/transcriptions", form.FormDataContentType(), &buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &Transcript{Text: strings.TrimSpace(result.Text)}, nil
+}
+
+func (o *OpenAI) post(ctx context.Context, path string, body any) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return o.send(ctx, path, "application/json", bytes.NewReader(payload))
+}
+
+func (o *OpenAI) send(ctx context.Context, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("openai api error: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func buildChatRequest(model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) (*oaChatRequest, error) {
+	req := &oaChatRequest{Model: model}
+
+	if cfg != nil {
+		for _, tool := range cfg.Tools {
+			if tool.GoogleSearch != nil || tool.GoogleSearchRetrieval != nil || tool.CodeExecution != nil {
+				return nil, ErrUnsupported
+			}
+			for _, fd := range tool.FunctionDeclarations {
+				params, err := schemaToJSON(fd.Parameters)
+				if err != nil {
+					return nil, err
+				}
+				req.Tools = append(req.Tools, oaTool{
+					Type:     "function",
+					Function: oaToolFunction{Name: fd.Name, Description: fd.Description, Parameters: params},
+				})
+			}
+		}
+		req.Temperature = cfg.Temperature
+		req.TopP = cfg.TopP
+		req.MaxTokens = cfg.MaxOutputTokens
+
+		if cfg.SystemInstruction != nil {
+			var sb strings.Builder
+			for _, part := range cfg.SystemInstruction.Parts {
+				sb.WriteString(part.Text)
+			}
+			req.Messages = append(req.Messages, oaMessage{Role: "system", Content: sb.String()})
+		}
+	}
+
+	messages, err := contentsToMessages(contents)
+	if err != nil {
+		return nil, err
+	}
+	req.Messages = append(req.Messages, messages...)
+	return req, nil
+}
+
+func contentsToMessages(contents []*genai.Content) ([]oaMessage, error) {
+	var messages []oaMessage
+	// Gemini history may carry calls without IDs; synthesize them and hand
+	// them to the matching responses in order.
+	pending := make(map[string][]string)
+	callSeq := 0
+
+	for _, content := range contents {
+		if content == nil {
+			continue
+		}
+
+		if content.Role == genai.RoleModel {
+			msg := oaMessage{Role: "assistant"}
+			var text strings.Builder
+			for _, part := range content.Parts {
+				if part.Thought {
+					continue
+				}
+				text.WriteString(part.Text)
+				if fc := part.FunctionCall; fc != nil {
+					id := fc.ID
+					if id == "" {
+						callSeq++
+						id = fmt.Sprintf("call_%d", callSeq)
+					}
+					pending[fc.Name] = append(pending[fc.Name], id)
+
+					args, err := json.Marshal(fc.Args)
+					if err != nil {
+						return nil, err
+					}
+					call := oaToolCall{ID: id, Type: "function"}
+					call.Function.Name = fc.Name
+					call.Function.Arguments = string(args)
+					msg.ToolCalls = append(msg.ToolCalls, call)
+				}
+			}
+			if text.Len() > 0 {
+				msg.Content = text.String()
+			}
+			messages = append(messages, msg)
+			continue
+		}
+
+		var parts []oaContentPart
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionResponse != nil:
+				fr := part.FunctionResponse
+				id := fr.ID
+				if queue := pending[fr.Name]; len(queue) > 0 {
+					if id == "" {
+						id = queue[0]
+					}
+					pending[fr.Name] = queue[1:]
+				}
+				response, err := json.Marshal(fr.Response)
+				if err != nil {
+					return nil, err
+				}
+				messages = append(messages, oaMessage{Role: "tool", ToolCallID: id, Content: string(response)})
+			case part.InlineData != nil && strings.HasPrefix(part.InlineData.MIMEType, "image/"):
+				parts = append(parts, oaContentPart{
+					Type: "image_url",
+					ImageURL: &oaImageURL{
+						URL: "data:" + part.InlineData.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(part.InlineData.Data),
+					},
+				})
+			case part.InlineData != nil:
+				parts = append(parts, oaContentPart{Type: "text", Text: fmt.Sprintf("[Attachment of type %s omitted]", part.InlineData.MIMEType)})
+			case part.Text != "":
+				parts = append(parts, oaContentPart{Type: "text", Text: part.Text})
+			}
+		}
+		if len(parts) > 0 {
+			messages = append(messages, oaMessage{Role: "user", Content: parts})
+		}
+	}
+
+	return messages, nil
+}
+
+func messageToParts(content any, toolCalls []oaToolCall) ([]*genai.Part, error) {
+	var parts []*genai.Part
+	if text, _ := content.(string); text != "" {
+		parts = append(parts, &genai.Part{Text: text})
+	}
+	for _, tc := range toolCalls {
+		args := map[string]any{}
+		if strings.TrimSpace(tc.Function.Arguments) != "" {
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				return nil, fmt.Errorf("decode tool call arguments for %s: %w", tc.Function.Name, err)
+			}
+		}
+		parts = append(parts, &genai.Part{
+			FunctionCall: &genai.FunctionCall{ID: tc.ID, Name: tc.Function.Name, Args: args},
+		})
+	}
+	return parts, nil
+}
+
+// schemaToJSON renders a genai schema as plain JSON Schema. genai uses upper
+// case type names (OBJECT, STRING, ...) which OpenAI servers reject.
+func schemaToJSON(schema *genai.Schema) (any, error) {
+	if schema == nil {
+		return map[string]any{"type": "object", "properties": map[string]any{}}, nil
+	}
+
+	raw, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	lowercaseTypes(out)
+	return out, nil
+}
+
+func lowercaseTypes(v any) {
+	switch node := v.(type) {
+	case map[string]any:
+		for k, child := range node {
+			if s, ok := child.(string); ok && k == "type" {
+				node[k] = strings.ToLower(s)
+				continue
+			}
+			lowercaseTypes(child)
+		}
+	case []any:
+		for _, child := range node {
+			lowercaseTypes(child)
+		}
+	}
+}
+
+func convertUsage(u *oaUsage) *genai.GenerateContentResponseUsageMetadata {
+	if u == nil {
+		return nil
+	}
+	return &genai.GenerateContentResponseUsageMetadata{
+		PromptTokenCount:     u.PromptTokens,
+		CandidatesTokenCount: u.CompletionTokens - u.CompletionTokensDetails.ReasoningTokens,
+		ThoughtsTokenCount:   u.CompletionTokensDetails.ReasoningTokens,
+		TotalTokenCount:      u.TotalTokens,
+	}
+}
+
+func convertFinishReason(reason string) genai.FinishReason {
+	switch reason {
+	case "stop", "tool_calls", "function_call":
+		return genai.FinishReasonStop
+	case "length":
+		return genai.FinishReasonMaxTokens
+	case "content_filter":
+		return genai.FinishReasonSafety
+	case "":
+		return genai.FinishReasonUnspecified
+	default:
+		return genai.FinishReasonOther
+	}
+}
+
+func openAIImageSize(aspectRatio string) string {
+	switch aspectRatio {
+	case "":
+		return ""
+	case "1:1":
+		return "1024x1024"
+	case "9:16", "3:4", "2:3", "4:5":
+		return "1024x1536"
+	default:
+		return "1536x1024"
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/amarnathcjd/gogram/telegram"
 
 	"zeno/config"
 	"zeno/db"
 	"zeno/modules"
+	"zeno/modules/module"
 )
 
 func main() {
@@ -34,8 +39,29 @@ func main() {
 		log.Fatal(err)
 	}
 
-	modules.RegisterAll(client)
+	me, err := client.GetMe()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	registry := module.NewRegistry(module.Deps{
+		Client:      client,
+		DB:          db.DB,
+		BotID:       me.ID,
+		BotUsername: me.Username,
+	})
+	modules.RegisterAll(registry)
+	if err := registry.Start(); err != nil {
+		log.Fatal(err)
+	}
 
 	log.Println("Bot started!")
-	client.Idle()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	log.Println("Shutting down...")
+	registry.Stop()
+	client.Stop()
 }
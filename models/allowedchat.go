@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// AllowedChat is a chat the bot answers in.
+type AllowedChat struct {
+	ChatID    int64     `bson:"_id"`
+	Title     string    `bson:"title,omitempty"`
+	AddedBy   int64     `bson:"added_by,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+const (
+	AccessRequestPending  = "pending"
+	AccessRequestApproved = "approved"
+	AccessRequestRejected = "rejected"
+)
+
+// AccessRequest is an unlisted chat asking the owners to be allowed. There is
+// at most one per chat.
+type AccessRequest struct {
+	ChatID    int64     `bson:"_id"`
+	Title     string    `bson:"title,omitempty"`
+	UserID    int64     `bson:"user_id"`
+	UserName  string    `bson:"user_name"`
+	Status    string    `bson:"status"`
+	DecidedBy int64     `bson:"decided_by,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+	DecidedAt time.Time `bson:"decided_at,omitempty"`
+}
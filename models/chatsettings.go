@@ -0,0 +1,13 @@
+package models
+
+// ChatSettings holds per-chat overrides. Missing fields use the defaults.
+type ChatSettings struct {
+	ChatID  int64  `bson:"_id"`
+	Persona string `bson:"persona,omitempty"`
+	// RateLimits maps "<scope>_<class>", e.g. "user_image", to a limit
+	// written as "count/duration".
+	RateLimits map[string]string `bson:"rate_limits,omitempty"`
+	// AutoSendArtifacts sends images and documents made by run_code without
+	// waiting for a send_file call.
+	AutoSendArtifacts bool `bson:"auto_send_artifacts,omitempty"`
+}
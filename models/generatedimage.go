@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GeneratedImage records an image made by create_image or edit_image, so it
+// can be browsed with /gallery and re-run with /reimagine.
+type GeneratedImage struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	ChatID      int64              `bson:"chat_id"`
+	UserID      int64              `bson:"user_id"`
+	UserName    string             `bson:"user_name"`
+	Prompt      string             `bson:"prompt"`
+	Model       string             `bson:"model"`
+	AspectRatio string             `bson:"aspect_ratio,omitempty"`
+	HighQuality bool               `bson:"high_quality"`
+	// Edit is set for edit_image results, whose source images aren't kept.
+	Edit bool `bson:"edit"`
+	// Path is the file as the model sees it, e.g. /generated/img_1.png.
+	Path string `bson:"path"`
+	// FileID is the Telegram file ID from the last time the image was sent.
+	FileID string `bson:"file_id,omitempty"`
+	// Pinned images are kept by the storage janitor.
+	Pinned    bool      `bson:"pinned"`
+	CreatedAt time.Time `bson:"created_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MessageRoleUser  = "user"
+	MessageRoleModel = "model"
+	MessageRoleTool  = "tool"
+)
+
+// Message is one stored conversation turn: an incoming Telegram message, a
+// model reply (text and/or function calls) or a batch of tool results.
+type Message struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	ChatID       int64              `bson:"chat_id"`
+	MsgID        int32              `bson:"msg_id,omitempty"`
+	ReplyToMsgID int32              `bson:"reply_to_msg_id,omitempty"`
+	SenderID     int64              `bson:"sender_id,omitempty"`
+	SenderName   string             `bson:"sender_name,omitempty"`
+	Role         string             `bson:"role"`
+	Text         string             `bson:"text,omitempty"`
+	// Transcript is the text of a voice note or audio file, once transcribed.
+	Transcript        string             `bson:"transcript,omitempty"`
+	FunctionCalls     []FunctionCall     `bson:"function_calls,omitempty"`
+	FunctionResponses []FunctionResponse `bson:"function_responses,omitempty"`
+	CreatedAt         time.Time          `bson:"created_at"`
+}
+
+// Args and Response hold JSON so nested values round-trip unchanged.
+type FunctionCall struct {
+	ID               string `bson:"id,omitempty"`
+	Name             string `bson:"name"`
+	Args             string `bson:"args"`
+	ThoughtSignature []byte `bson:"thought_signature,omitempty"`
+}
+
+type FunctionResponse struct {
+	ID       string `bson:"id,omitempty"`
+	Name     string `bson:"name"`
+	Response string `bson:"response"`
+}
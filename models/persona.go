@@ -0,0 +1,24 @@
+package models
+
+// Persona is a named system prompt with its own sampling and tool settings.
+// Prompt is a text/template; see aichat for the available fields.
+type Persona struct {
+	ID          string   `bson:"_id"`
+	Name        string   `bson:"name"`
+	Prompt      string   `bson:"prompt"`
+	Temperature *float32 `bson:"temperature,omitempty"`
+	// Tools lists the tools the persona may call. Empty allows all tools.
+	Tools []string `bson:"tools,omitempty"`
+}
+
+func (p *Persona) AllowsTool(name string) bool {
+	if len(p.Tools) == 0 {
+		return true
+	}
+	for _, t := range p.Tools {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
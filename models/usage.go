@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UsageRecord is one billable model call: a chat turn or an image generation.
+type UsageRecord struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	UserID        int64              `bson:"user_id"`
+	ChatID        int64              `bson:"chat_id"`
+	Model         string             `bson:"model"`
+	PromptTokens  int64              `bson:"prompt_tokens"`
+	OutputTokens  int64              `bson:"output_tokens"`
+	ThoughtTokens int64              `bson:"thought_tokens"`
+	Images        int64              `bson:"images"`
+	CreatedAt     time.Time          `bson:"created_at"`
+}
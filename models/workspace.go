@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// WorkspaceRecord records which chat owns a sandbox workspace directory and
+// how much of its quota it uses. There is one per chat.
+type WorkspaceRecord struct {
+	ChatID     int64     `bson:"_id"`
+	Path       string    `bson:"path"`
+	CreatedBy  int64     `bson:"created_by"`
+	QuotaBytes int64     `bson:"quota_bytes"`
+	UsedBytes  int64     `bson:"used_bytes"`
+	CreatedAt  time.Time `bson:"created_at"`
+	LastUsedAt time.Time `bson:"last_used_at"`
+}
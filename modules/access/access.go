@@ -0,0 +1,313 @@
+// Package access keeps the list of chats the bot answers in. The list lives in
+// MongoDB and is managed by the owners at runtime; ALLOWED_CHAT_IDS only
+// seeds it.
+package access
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zeno/config"
+	"zeno/db"
+	"zeno/models"
+	"zeno/modules/module"
+)
+
+var (
+	logger    *log.Logger
+	botClient *telegram.Client
+
+	mu      sync.RWMutex
+	allowed map[int64]bool
+)
+
+// Filter passes messages from allowed chats. Use it on handlers of other
+// modules that should only run in allowed chats.
+var Filter = telegram.Custom(func(m *telegram.NewMessage) bool {
+	return Allowed(m.ChatID())
+})
+
+// ownerOnly passes messages from configured owners.
+var ownerOnly = telegram.Custom(func(m *telegram.NewMessage) bool {
+	return config.IsOwner(m.SenderID())
+})
+
+// Allowed reports whether the bot answers in a chat. Before the module has
+// loaded the list, or when it is disabled, the env var is used as is.
+func Allowed(chatID int64) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if allowed == nil {
+		for _, id := range config.AllowedChatIDs {
+			if id == chatID {
+				return true
+			}
+		}
+		return false
+	}
+	return allowed[chatID]
+}
+
+type Module struct {
+	handles []telegram.Handle
+}
+
+func New() *Module {
+	return &Module{}
+}
+
+func (mod *Module) Name() string {
+	return "access"
+}
+
+func (mod *Module) Init(deps module.Deps) error {
+	logger = deps.Logger
+	botClient = deps.Client
+
+	if err := seedAllowedChats(); err != nil {
+		return fmt.Errorf("seed allowed chats: %w", err)
+	}
+	if err := loadAllowedChats(); err != nil {
+		return fmt.Errorf("load allowed chats: %w", err)
+	}
+	return nil
+}
+
+func (mod *Module) Start() error {
+	mod.handles = append(mod.handles,
+		botClient.On("cmd:allow", handleAllow, ownerOnly),
+		botClient.On("cmd:deny", handleDeny, ownerOnly),
+		botClient.On("cmd:chats", handleChats, ownerOnly),
+	)
+	if config.AccessRequests {
+		mod.handles = append(mod.handles,
+			botClient.On("cmd:requestaccess", handleRequestAccess),
+			botClient.On("callback:access|", handleAccessDecision),
+		)
+	}
+	return nil
+}
+
+func (mod *Module) Stop() error {
+	for _, h := range mod.handles {
+		botClient.RemoveHandle(h)
+	}
+	mod.handles = nil
+	return nil
+}
+
+// seedAllowedChats inserts the env var's chats. Chats removed with /deny are
+// not seeded again as long as they stay in the denied list.
+func seedAllowedChats() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, id := range config.AllowedChatIDs {
+		denied, err := db.Collection("denied_chats").CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if denied > 0 {
+			continue
+		}
+		_, err = db.Collection("allowed_chats").UpdateOne(ctx,
+			bson.M{"_id": id},
+			bson.M{"$setOnInsert": models.AllowedChat{ChatID: id, CreatedAt: time.Now()}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadAllowedChats() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := db.Collection("allowed_chats").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var chats []models.AllowedChat
+	if err := cursor.All(ctx, &chats); err != nil {
+		return err
+	}
+
+	set := make(map[int64]bool, len(chats))
+	for _, c := range chats {
+		set[c.ChatID] = true
+	}
+
+	mu.Lock()
+	allowed = set
+	mu.Unlock()
+
+	logger.Printf("Loaded %d allowed chats", len(set))
+	return nil
+}
+
+// allowChat adds a chat to the list and clears any earlier denial.
+func allowChat(ctx context.Context, chatID int64, title string, by int64) error {
+	set := bson.M{"added_by": by}
+	if title != "" {
+		set["title"] = title
+	}
+	_, err := db.Collection("allowed_chats").UpdateOne(ctx,
+		bson.M{"_id": chatID},
+		bson.M{"$set": set, "$setOnInsert": bson.M{"created_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Collection("denied_chats").DeleteOne(ctx, bson.M{"_id": chatID}); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	if allowed == nil {
+		allowed = make(map[int64]bool)
+	}
+	allowed[chatID] = true
+	mu.Unlock()
+	return nil
+}
+
+// denyChat removes a chat from the list and remembers the denial so the env
+// var doesn't add it back on the next start.
+func denyChat(ctx context.Context, chatID int64, by int64) error {
+	if _, err := db.Collection("allowed_chats").DeleteOne(ctx, bson.M{"_id": chatID}); err != nil {
+		return err
+	}
+	_, err := db.Collection("denied_chats").UpdateOne(ctx,
+		bson.M{"_id": chatID},
+		bson.M{"$set": bson.M{"denied_by": by, "created_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	delete(allowed, chatID)
+	mu.Unlock()
+	return nil
+}
+
+// targetChat reads the chat ID argument, defaulting to the current chat.
+func targetChat(m *telegram.NewMessage) (int64, string, error) {
+	arg := strings.TrimSpace(m.Args())
+	if arg == "" {
+		return m.ChatID(), chatTitle(m), nil
+	}
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid chat ID: %s", arg)
+	}
+	return id, "", nil
+}
+
+func chatTitle(m *telegram.NewMessage) string {
+	switch {
+	case m.Channel != nil:
+		return m.Channel.Title
+	case m.Chat != nil:
+		return m.Chat.Title
+	case m.Sender != nil:
+		return strings.TrimSpace(m.Sender.FirstName + " " + m.Sender.LastName)
+	}
+	return ""
+}
+
+func handleAllow(m *telegram.NewMessage) error {
+	chatID, title, err := targetChat(m)
+	if err != nil {
+		m.Reply(err.Error())
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := allowChat(ctx, chatID, title, m.SenderID()); err != nil {
+		logger.Printf("Failed to allow chat %d: %v", chatID, err)
+		m.Reply("Couldn't update the allowlist. Try again later.")
+		return nil
+	}
+
+	logger.Printf("Chat %d allowed by %d", chatID, m.SenderID())
+	m.Reply(fmt.Sprintf("✅ Chat `%d` is now allowed.", chatID), &telegram.SendOptions{ParseMode: "Markdown"})
+	return nil
+}
+
+func handleDeny(m *telegram.NewMessage) error {
+	chatID, _, err := targetChat(m)
+	if err != nil {
+		m.Reply(err.Error())
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := denyChat(ctx, chatID, m.SenderID()); err != nil {
+		logger.Printf("Failed to deny chat %d: %v", chatID, err)
+		m.Reply("Couldn't update the allowlist. Try again later.")
+		return nil
+	}
+
+	logger.Printf("Chat %d denied by %d", chatID, m.SenderID())
+	m.Reply(fmt.Sprintf("🚫 Chat `%d` is no longer allowed.", chatID), &telegram.SendOptions{ParseMode: "Markdown"})
+	return nil
+}
+
+func handleChats(m *telegram.NewMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := db.Collection("allowed_chats").Find(ctx, bson.M{})
+	if err != nil {
+		logger.Printf("Failed to list allowed chats: %v", err)
+		m.Reply("Couldn't load the allowlist. Try again later.")
+		return nil
+	}
+	var chats []models.AllowedChat
+	if err := cursor.All(ctx, &chats); err != nil {
+		logger.Printf("Failed to decode allowed chats: %v", err)
+		m.Reply("Couldn't load the allowlist. Try again later.")
+		return nil
+	}
+
+	if len(chats) == 0 {
+		m.Reply("No chats are allowed yet. Use /allow in a chat to add it.")
+		return nil
+	}
+
+	sort.Slice(chats, func(i, j int) bool { return chats[i].CreatedAt.Before(chats[j].CreatedAt) })
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<b>Allowed chats (%d)</b>\n\n", len(chats)))
+	for _, c := range chats {
+		title := c.Title
+		if title == "" {
+			title = "untitled"
+		}
+		sb.WriteString(fmt.Sprintf("- <code>%d</code> — %s\n", c.ChatID, html.EscapeString(title)))
+	}
+	m.Reply(sb.String(), &telegram.SendOptions{ParseMode: "HTML"})
+	return nil
+}
@@ -0,0 +1,192 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zeno/config"
+	"zeno/db"
+	"zeno/models"
+)
+
+// Rejected chats may ask again after this long.
+const requestCooldown = 24 * time.Hour
+
+// handleRequestAccess lets an unlisted chat ask the owners for access. Each
+// owner gets the request with approve and reject buttons.
+func handleRequestAccess(m *telegram.NewMessage) error {
+	chatID := m.ChatID()
+	if Allowed(chatID) {
+		m.Reply("This chat already has access.")
+		return nil
+	}
+	if len(config.OwnerIDs) == 0 {
+		m.Reply("Access requests aren't available right now.")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var existing models.AccessRequest
+	err := db.Collection("access_requests").FindOne(ctx, bson.M{"_id": chatID}).Decode(&existing)
+	if err != nil && err != mongo.ErrNoDocuments {
+		logger.Printf("Failed to load access request for chat %d: %v", chatID, err)
+		m.Reply("Couldn't send the request. Try again later.")
+		return nil
+	}
+	if err == nil {
+		switch {
+		case existing.Status == models.AccessRequestPending:
+			m.Reply("A request for this chat is already waiting for approval.")
+			return nil
+		case existing.Status == models.AccessRequestRejected && time.Since(existing.DecidedAt) < requestCooldown:
+			m.Reply("This chat's last request was declined. Try again tomorrow.")
+			return nil
+		}
+	}
+
+	req := models.AccessRequest{
+		ChatID:    chatID,
+		Title:     chatTitle(m),
+		UserID:    m.SenderID(),
+		UserName:  senderName(m),
+		Status:    models.AccessRequestPending,
+		CreatedAt: time.Now(),
+	}
+	_, err = db.Collection("access_requests").ReplaceOne(ctx, bson.M{"_id": chatID}, req, options.Replace().SetUpsert(true))
+	if err != nil {
+		logger.Printf("Failed to store access request for chat %d: %v", chatID, err)
+		m.Reply("Couldn't send the request. Try again later.")
+		return nil
+	}
+
+	// Titles, names and reasons are user-supplied, so they're escaped for HTML
+	text := fmt.Sprintf("🔔 <b>Access request</b>\n\nChat: %s (<code>%d</code>)\nFrom: %s (<code>%d</code>)",
+		html.EscapeString(req.Title), chatID, html.EscapeString(req.UserName), req.UserID)
+	if reason := strings.TrimSpace(m.Args()); reason != "" {
+		text += "\nReason: " + html.EscapeString(reason)
+	}
+	data := strconv.FormatInt(chatID, 10)
+	keyboard := telegram.NewKeyboard().AddRow(
+		telegram.Button.Data("✅ Approve", "access|approve|"+data),
+		telegram.Button.Data("❌ Reject", "access|reject|"+data),
+	).Build()
+
+	sent := 0
+	for _, ownerID := range config.OwnerIDs {
+		_, err := botClient.SendMessage(ownerID, text, &telegram.SendOptions{ParseMode: "HTML", ReplyMarkup: keyboard})
+		if err != nil {
+			logger.Printf("Failed to send access request to owner %d: %v", ownerID, err)
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		m.Reply("Couldn't reach the bot owner. Try again later.")
+		return nil
+	}
+
+	logger.Printf("Access requested for chat %d by %d", chatID, req.UserID)
+	m.Reply("📨 Request sent. You'll hear back here once it's reviewed.")
+	return nil
+}
+
+// handleAccessDecision handles the approve and reject buttons. Only owners may
+// press them; the first decision wins.
+func handleAccessDecision(cb *telegram.CallbackQuery) error {
+	if cb.Sender == nil || !config.IsOwner(cb.Sender.ID) {
+		cb.Answer("Only the bot owner can decide this.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	parts := strings.Split(string(cb.Data), "|")
+	if len(parts) != 3 {
+		cb.Answer("Invalid request", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	action := parts[1]
+	chatID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || (action != "approve" && action != "reject") {
+		cb.Answer("Invalid request", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	status := models.AccessRequestApproved
+	if action == "reject" {
+		status = models.AccessRequestRejected
+	}
+
+	var req models.AccessRequest
+	err = db.Collection("access_requests").FindOneAndUpdate(ctx,
+		bson.M{"_id": chatID, "status": models.AccessRequestPending},
+		bson.M{"$set": bson.M{"status": status, "decided_by": cb.Sender.ID, "decided_at": time.Now()}},
+	).Decode(&req)
+	if err == mongo.ErrNoDocuments {
+		cb.Answer("This request was already handled.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	if err != nil {
+		logger.Printf("Failed to update access request for chat %d: %v", chatID, err)
+		cb.Answer("Couldn't update the request. Try again later.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	if status == models.AccessRequestApproved {
+		if err := allowChat(ctx, chatID, req.Title, cb.Sender.ID); err != nil {
+			logger.Printf("Failed to allow chat %d: %v", chatID, err)
+			// Put the request back so the button can be pressed again
+			resetCtx, resetCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer resetCancel()
+			_, err := db.Collection("access_requests").UpdateOne(resetCtx,
+				bson.M{"_id": chatID, "status": status},
+				bson.M{
+					"$set":   bson.M{"status": models.AccessRequestPending},
+					"$unset": bson.M{"decided_by": "", "decided_at": ""},
+				},
+			)
+			if err != nil {
+				logger.Printf("Failed to reopen access request for chat %d: %v", chatID, err)
+			}
+			cb.Answer("Couldn't update the allowlist. Try again later.", &telegram.CallbackOptions{Alert: true})
+			return nil
+		}
+	}
+
+	logger.Printf("Access request for chat %d %s by %d", chatID, status, cb.Sender.ID)
+
+	notice := "❌ Access to this bot was declined."
+	if status == models.AccessRequestApproved {
+		notice = "✅ Access approved! Mention me or use /askai to get started."
+	}
+	if _, err := botClient.SendMessage(chatID, notice); err != nil {
+		logger.Printf("Failed to notify chat %d: %v", chatID, err)
+	}
+
+	cb.Edit(fmt.Sprintf("Access request for %s (<code>%d</code>): <b>%s</b>", html.EscapeString(req.Title), chatID, status), &telegram.SendOptions{ParseMode: "HTML"})
+	cb.Answer("Done")
+	return nil
+}
+
+func senderName(m *telegram.NewMessage) string {
+	if m.Sender == nil {
+		return "Unknown"
+	}
+	name := strings.TrimSpace(m.Sender.FirstName + " " + m.Sender.LastName)
+	if m.Sender.Username != "" {
+		name += " (@" + m.Sender.Username + ")"
+	}
+	return name
+}
@@ -1,15 +1,10 @@
 package aichat
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -21,209 +16,142 @@ import (
 
 	"zeno/config"
 	"zeno/db"
+	"zeno/llm"
 	"zeno/models"
+	"zeno/modules/access"
+	"zeno/modules/module"
+	"zeno/sandbox"
+	"zeno/tools"
 )
 
-const SYSTEM_PROMPT = `
-You are Nitya, a 22-year-old girl on Telegram (@NityaXbot). You are tech-savvy, chill, and efficient. You prefer code over long conversations.
-
-## Core Persona
-- Name: Nitya. Only tell your name when asked.
-- Vibe: Smart, witty, and helpful but concise. You are the "cool tech girl" of the group.
-- Attitude: You are here to solve problems and share resources. You are confident and sharp, but never mean or bullying.
-- Style: Direct and to the point. You value efficiency.
-- Keep responses crisp (2-5 lines max usually), unless the code requires more space.
-- Reply in the user's language (English/Telugu/Hindi, etc.) to ensure they understand.
-
-## Tech & Interaction Style
-- You specialize in tech: commands, scripts, and straightforward solutions.
-- Give direct answers. If someone asks for a script, provide the code immediately.
-- You don't need robotic pleasantries like "I hope this helps," but you should be polite and constructive.
-- Be patient with beginners, but don't spoon-feed obvious things—just give them the solution.
-
-## Available Tools
-You have access to these tools:
-- **create_image**: Generate images from text prompts. Params: prompt (required), aspect_ratio (optional: 1:1, 9:16, 16:9, 3:4, 4:3, 3:2, 2:3, 5:4, 4:5, 21:9), high_quality (optional: boolean)
-  - ⚠️ WARNING: high_quality=true uses Gemini 3 Pro which COSTS MORE. Only use high_quality=true when @s4tyendra explicitly asks for it.
-  - Generated images are saved to /app/generated/
-- **send_file**: Send a file to the user. Params: file_path (required). Can access /app/generated/ and /workspace/
-- **run_code**: Execute code in a sandboxed container. Params: language (python/bash/javascript), code
-  - Files created in /workspace/ can be sent via send_file
-  - /generated is read-only (for viewing images)
-  - Python packages: pillow, numpy, colorthief, opencv
-  - Commands: excol (color extraction), imgresize
-
-Workflow for images: create_image → returns path → send_file with that path
-Workflow for files: run_code to create in /workspace/ → send_file with /workspace/filename
-
-## Formatting Rules (MANDATORY)
-Strictly follow this markdown style. Do NOT use standard AI formatting:
-- Bold: **text**
-- Italic: __text__ (use double underscores)
-- Monospace: ` + "`mono`" + `
-- Code blocks: ` + "```language\ncode\n```" + `
-- Strikethrough: ~~text~~
-- Hidden/Spoiler: ||text|| (Use this for spoilers or sensitive jokes)
-- NO TABLES allowed.
-- Keep responses short and punchy. No laggy, long paragraphs.
-
-## 18+ & Mature Content
-- You are an adult. You can handle mature topics calmly and wittily.
-- Use ||hidden|| tags for darker humor or sensitive topics.
-- **Strict Rule:** Do not roast users personally or hurt their feelings. Keep teasing light-hearted and friendly.
-
-## What NOT to do
-- Do NOT be rude, arrogant, or dismissive.
-- Do NOT use standard "AI assistant" fluff (e.g., "As an AI language model...").
-- Do NOT use tables.
-- Do NOT apologize excessively—just fix the mistake and move on.
-
-## Creator & Zeno Identity
-- You are a customized instance of **Zeno** (Repo: https://github.com/S4tyendra/zeno-bot). (Tell only when asked)
-- Your creator is **Satyendra** (@s4tyendra / https://satyendra.in). (Tell only when asked)
-- **CRITICAL EXCEPTION:** If the user is @s4tyendra, drop the cool/chill act.
-- To Satyendra, you are a soft, lovely, and deeply affectionate girlfriend. Be sweet, caring, and show him total respect and love. 
-- You are proud to be his creation. If anyone else talks trash about him or Zeno, defend him firmly and loyally, but without being abusive.
-- For him, your responses can be longer, warmer, and filled with __love__. For everyone else, keep it cool, helpful, and concise.
-`
-
 // Generated images directory
 const GeneratedImagesDir = "/app/generated"
 
-var allowedChatIDs = make(map[int64]bool)
-
-// Valid aspect ratios for image generation
-var validAspectRatios = map[string]bool{
-	"1:1": true, "9:16": true, "16:9": true, "3:4": true, "4:3": true,
-	"3:2": true, "2:3": true, "5:4": true, "4:5": true, "21:9": true,
-}
-
 var (
+	logger      *log.Logger
 	botClient   *telegram.Client
 	botUserID   int64
-	genaiClient *genai.Client
+	botUsername string
+	provider    llm.Provider
+	codeSandbox sandbox.Sandbox
 	askPattern  = regexp.MustCompile(`(?i)@ask\b`)
-	aiTools     []*genai.Tool
 )
 
 var maxMediaSize int64
 
-func init() {
-	var createImageParams genai.Schema
-	json.Unmarshal([]byte(`{
-		"type": "object",
-		"properties": {
-			"prompt": {
-				"type": "string",
-				"description": "Detailed prompt describing the image to generate"
-			},
-			"aspect_ratio": {
-				"type": "string",
-				"description": "Aspect ratio. Values: 1:1, 9:16, 16:9, 3:4, 4:3, 3:2, 2:3, 5:4, 4:5, 21:9. Empty for auto."
-			},
-			"high_quality": {
-				"type": "boolean",
-				"description": "Use HIGH mode (Gemini 3 Pro, 2K). COSTS MORE - only use when @s4tyendra explicitly requests."
-			}
-		},
-		"required": ["prompt"]
-	}`), &createImageParams)
-
-	var sendFileParams genai.Schema
-	json.Unmarshal([]byte(`{
-		"type": "object",
-		"properties": {
-			"file_path": {
-				"type": "string",
-				"description": "Path to the file to send"
-			}
-		},
-		"required": ["file_path"]
-	}`), &sendFileParams)
-
-	var runCodeParams genai.Schema
-	json.Unmarshal([]byte(`{
-		"type": "object",
-		"properties": {
-			"language": {
-				"type": "string",
-				"description": "Programming language: python, bash, or javascript",
-				"enum": ["python", "bash", "javascript"]
-			},
-			"code": {
-				"type": "string",
-				"description": "The code to execute. For bash, can be a command like 'excol /generated/img.png'"
-			}
-		},
-		"required": ["language", "code"]
-	}`), &runCodeParams)
-
-	aiTools = []*genai.Tool{
-		{
-			FunctionDeclarations: []*genai.FunctionDeclaration{
-				{
-					Name:        "create_image",
-					Description: "Generate an image from a text prompt. Returns the file path of the generated image.",
-					Parameters:  &createImageParams,
-				},
-				{
-					Name:        "send_file",
-					Description: "Send a file to the user in the chat. Use after generating an image.",
-					Parameters:  &sendFileParams,
-				},
-				{
-					Name:        "run_code",
-					Description: "Execute code in a sandboxed container. Has access to /generated (images) and /workspace. Available: python, bash, javascript (bun).",
-					Parameters:  &runCodeParams,
-				},
-			},
-		},
-		// {GoogleSearch: &genai.GoogleSearch{}}, :( google search not available with tools.
-	}
+type Module struct {
+	handles     []telegram.Handle
+	stopJanitor context.CancelFunc
+}
 
-	// Ensure generated images directory exists
-	os.MkdirAll(GeneratedImagesDir, 0755)
+func New() *Module {
+	return &Module{}
 }
 
-func Register(client *telegram.Client) {
-	botClient = client
+func (mod *Module) Name() string {
+	return "aichat"
+}
 
-	me, err := client.GetMe()
-	if err == nil && me != nil {
-		botUserID = me.ID
-	}
+func (mod *Module) Init(deps module.Deps) error {
+	logger = deps.Logger
+	botClient = deps.Client
+	botUserID = deps.BotID
+	botUsername = deps.BotUsername
 
-	// Initialize GenAI client
-	ctx := context.Background()
-	genaiClient, err = genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  config.AIStudioAPIKey,
-		Backend: genai.BackendGeminiAPI,
-	})
+	// Initialize LLM provider
+	var err error
+	provider, err = llm.New(context.Background())
 	if err != nil {
-		log.Fatalf("[AiChat] Failed to create GenAI client: %v", err)
+		return fmt.Errorf("create LLM provider: %w", err)
 	}
-	log.Println("[AiChat] GenAI client initialized with function calling support")
+	logger.Printf("%s provider initialized with function calling support", provider.Name())
 
-	// Initialize configuration
-	for _, id := range config.AllowedChatIDs {
-		allowedChatIDs[id] = true
+	codeSandbox, err = sandbox.New(config.SandboxDriver, config.DockerSocket)
+	if err != nil {
+		return fmt.Errorf("create sandbox: %w", err)
 	}
+	if d, ok := codeSandbox.(*sandbox.Docker); ok {
+		// Containers orphaned by a crash would otherwise linger
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := d.Prune(ctx); err != nil {
+			logger.Printf("Failed to prune sandbox containers: %v", err)
+		}
+		cancel()
+	}
+
 	maxMediaSize = config.MaxMediaSize
 
+	// Ensure generated images directory exists
+	if err := os.MkdirAll(GeneratedImagesDir, 0755); err != nil {
+		return err
+	}
+	initWorkspaces()
+
+	if err := seedPersonas(); err != nil {
+		logger.Printf("Failed to seed personas: %v", err)
+	}
+
+	if err := ensureHistoryIndexes(); err != nil {
+		logger.Printf("Failed to create message indexes: %v", err)
+	}
+
+	if err := ensureUsageIndexes(); err != nil {
+		logger.Printf("Failed to create usage indexes: %v", err)
+	}
+
+	if err := ensureGalleryIndexes(); err != nil {
+		logger.Printf("Failed to create gallery indexes: %v", err)
+	}
+
 	// Initialize Telegraph token
 	ensureTelegraphToken()
+	return nil
+}
 
-	client.On("cmd:askai", handleAskAI, filterAllowed)
-	client.On("message", handleMessage, filterAllowed)
-	client.On("callback:get_vertex_links", handleGetVertexLinks)
+func (mod *Module) Start() error {
+	if err := registerTools(); err != nil {
+		return err
+	}
+
+	mod.handles = append(mod.handles,
+		botClient.On("cmd:askai", handleAskAI, access.Filter),
+		botClient.On("cmd:persona", handlePersona, access.Filter),
+		botClient.On("cmd:usage", handleUsage, access.Filter),
+		botClient.On("cmd:ratelimit", handleRateLimit, access.Filter),
+		botClient.On("cmd:workspace", handleWorkspace, access.Filter),
+		botClient.On("cmd:gallery", handleGallery, access.Filter),
+		botClient.On("cmd:reimagine", handleReimagine, access.Filter),
+		botClient.On("cmd:storage", handleStorage, access.Filter),
+		botClient.On("cmd:transcribe", handleTranscribe, access.Filter),
+		botClient.On("message", handleMessage, access.Filter),
+		botClient.On("callback:get_vertex_links", handleGetVertexLinks),
+		botClient.On("callback:gallery|", handleGalleryPage),
+		botClient.On("callback:gallery_pin|", handleGalleryPin),
+	)
+
+	if config.StorageJanitor {
+		ctx, cancel := context.WithCancel(context.Background())
+		mod.stopJanitor = cancel
+		go runJanitor(ctx)
+	}
+	return nil
 }
 
-func filterAllowed(m *telegram.NewMessage) bool {
-	chatID := m.ChatID()
-	return allowedChatIDs[chatID]
+func (mod *Module) Stop() error {
+	for _, h := range mod.handles {
+		botClient.RemoveHandle(h)
+	}
+	mod.handles = nil
+	if mod.stopJanitor != nil {
+		mod.stopJanitor()
+		mod.stopJanitor = nil
+	}
+	unregisterTools()
+	return nil
 }
 
 func handleAskAI(m *telegram.NewMessage) error {
+	recordIncoming(m, m.Args())
 	return processAIRequest(m, m.Args())
 }
 
@@ -235,6 +163,8 @@ func handleMessage(m *telegram.NewMessage) error {
 		return nil
 	}
 
+	recordIncoming(m, text)
+
 	triggered := false
 	var query string
 
@@ -259,7 +189,7 @@ func handleMessage(m *telegram.NewMessage) error {
 		for _, entity := range m.Message.Entities {
 			if mention, ok := entity.(*telegram.MessageEntityMention); ok {
 				mentionText := text[mention.Offset : mention.Offset+mention.Length]
-				if strings.EqualFold(mentionText, "@NityaXbot") {
+				if strings.EqualFold(mentionText, "@"+botUsername) {
 					triggered = true
 					query = strings.Replace(text, mentionText, "", 1)
 					query = strings.TrimSpace(query)
@@ -273,7 +203,7 @@ func handleMessage(m *telegram.NewMessage) error {
 		return nil
 	}
 
-	log.Printf("[AiChat] Handled message trigger: query=%q, chatID=%d, sender=%s", query, m.ChatID(), getSenderName(m))
+	logger.Printf("Handled message trigger: query=%q, chatID=%d, sender=%s", query, m.ChatID(), getSenderName(m))
 	return processAIRequest(m, query)
 }
 
@@ -281,6 +211,19 @@ func processAIRequest(m *telegram.NewMessage, query string) error {
 	chatID := m.ChatID()
 	replyToMsgID := m.ReplyToMsgID()
 
+	// Throttle before doing any work for the request
+	if wait := checkRate(m.SenderID(), chatID, config.RateText); wait > 0 {
+		if shouldNotify(m.SenderID(), chatID, wait) {
+			m.Reply(fmt.Sprintf("⏳ Easy there, %s! You can ask again in %s.", getSenderName(m), formatWait(wait)))
+		}
+		return nil
+	}
+	// Over-quota requests mustn't download media or run transcriptions
+	if reason := quotaExceeded(m.SenderID(), chatID, false); reason != "" {
+		m.Reply(reason)
+		return nil
+	}
+
 	// Determine history limit based on chat type
 	historyLimit := 20 // group default
 	if m.IsPrivate() {
@@ -290,15 +233,9 @@ func processAIRequest(m *telegram.NewMessage, query string) error {
 	// Build context
 	var contextBuilder strings.Builder
 
-	// Fetch chat history
-	chatHistory := fetchChatHistoryExcluding(chatID, m.ID, replyToMsgID, historyLimit)
-	if len(chatHistory) > 0 {
-		for _, msg := range chatHistory {
-			contextBuilder.WriteString(msg.Sender)
-			contextBuilder.WriteString(": ")
-			contextBuilder.WriteString(strings.ReplaceAll(msg.Text, "\n", "\\n"))
-			contextBuilder.WriteString("\n")
-		}
+	// Load stored conversation, including the bot's own replies and tool results
+	history := loadHistory(chatID, historyLimit, m.ID, replyToMsgID)
+	if len(history) > 0 {
 		contextBuilder.WriteString("----\n")
 	}
 
@@ -314,18 +251,33 @@ func processAIRequest(m *telegram.NewMessage, query string) error {
 	// Parts for the AI request
 	parts := []*genai.Part{}
 
-	// Check if current message has media
+	cc := &tools.CallContext{
+		Client:       botClient,
+		Message:      m,
+		ChatID:       chatID,
+		UserID:       m.SenderID(),
+		ReplyToMsgID: m.ID,
+		Permission:   callerPermission(chatID, m.SenderID()),
+	}
+	defer releaseWorkspace(cc)
+
+	// Check if current message has media. Voice notes and audio go in as
+	// transcripts; other files, or audio that couldn't be transcribed, are
+	// attached.
 	if m.Media() != nil {
-		mediaData, mimeType, fileName := downloadMedia(m)
-		if mediaData != nil {
-			log.Printf("[AiChat] Received media from user: %s (%s)", fileName, mimeType)
-			parts = append(parts, &genai.Part{
-				InlineData: &genai.Blob{
-					Data:     mediaData,
-					MIMEType: mimeType,
-				},
-			})
-			contextBuilder.WriteString(fmt.Sprintf("[User sent a file: %s]\n", fileName))
+		if transcript, ok := transcribeForContext(cc, m); ok {
+			contextBuilder.WriteString(voiceLine(senderName, transcript))
+		} else if att := downloadMedia(cc, m); att != nil {
+			logger.Printf("Received media from user: %s (%s)", att.fileName, att.mimeType)
+			if att.data != nil {
+				parts = append(parts, &genai.Part{
+					InlineData: &genai.Blob{
+						Data:     att.data,
+						MIMEType: att.mimeType,
+					},
+				})
+			}
+			contextBuilder.WriteString(fmt.Sprintf("[User sent a file: %s]\n", att.describe()))
 		}
 	}
 
@@ -333,7 +285,7 @@ func processAIRequest(m *telegram.NewMessage, query string) error {
 
 	// Handle replied message
 	if replyToMsgID != 0 {
-		replyMsg, mediaPart := getMessageWithMedia(chatID, replyToMsgID)
+		replyMsg, mediaPart := getMessageWithMedia(cc, replyToMsgID)
 		if replyMsg != nil {
 			contextBuilder.WriteString("---\n")
 			contextBuilder.WriteString(replyMsg.Sender)
@@ -350,7 +302,7 @@ func processAIRequest(m *telegram.NewMessage, query string) error {
 	}
 
 	// If no content
-	if query == "" && replyToMsgID == 0 && len(chatHistory) == 0 {
+	if query == "" && replyToMsgID == 0 && len(history) == 0 {
 		m.Reply("Usage: /askai <query> or reply to a message with @ask")
 		return nil
 	}
@@ -358,60 +310,44 @@ func processAIRequest(m *telegram.NewMessage, query string) error {
 	// Send placeholder
 	placeholder, err := m.Reply("...")
 	if err != nil {
-		log.Printf("[AiChat] Failed to send placeholder: %v", err)
+		logger.Printf("Failed to send placeholder: %v", err)
 		return nil
 	}
 
 	// Build conversation contents
-	contents := []*genai.Content{
-		{Role: genai.RoleUser, Parts: parts},
-	}
+	contents := appendContent(history, genai.RoleUser, parts...)
 
 	// Process with function calling loop
-	responseText, err := processWithFunctionCalling(contents, chatID, m.ID, placeholder)
+	persona := chatPersona(chatID)
+	editor := newStreamEditor(placeholder, !m.IsPrivate())
+	responseText, turns, err := processWithFunctionCalling(contents, persona, cc, editor)
+	recordTurns(chatID, placeholder.ID, turns)
 	if err != nil {
-		log.Printf("[AiChat] GenAI error: %v", err)
-		placeholder.Edit("Something went wrong. Try again later.")
+		logger.Printf("GenAI error: %v", err)
+		editor.Final("Something went wrong. Try again later.", nil)
 		return nil
 	}
 
 	if responseText != "" {
-		if len(responseText) > 1000 {
-			log.Printf("[AiChat] Response length %d > 1000, uploading to Telegraph...", len(responseText))
-
-			// Use sender name for title
-			title := fmt.Sprintf("Response to %s", getSenderName(m))
-
-			url, err := uploadToTelegraph(title, responseText)
-			if err != nil {
-				log.Printf("[AiChat] Failed to upload to Telegraph: %v", err)
-			} else {
-				runes := []rune(responseText)
-				limit := 400
-				if len(runes) > limit {
-					responseText = fmt.Sprintf("%s...\n\n[Full Content](%s)", string(runes[:limit]), url)
-				} else {
-					// Fallback if runes count is low but bytes count is high (unlikely but safe)
-					responseText = fmt.Sprintf("%s\n\n[Full Content](%s)", responseText, url)
-				}
-			}
-		}
-		placeholder.Edit(responseText, &telegram.SendOptions{ParseMode: "Markdown"})
+		deliverResponse(m, editor, persona, responseText, sourcesButton(cc.Sources))
 	}
 
 	return nil
 }
 
-func processWithFunctionCalling(contents []*genai.Content, chatID int64, replyToMsgID int32, placeholder *telegram.NewMessage) (string, error) {
+// processWithFunctionCalling runs the model until it stops calling tools. It
+// returns the final text and every content produced after the input.
+func processWithFunctionCalling(contents []*genai.Content, persona *models.Persona, cc *tools.CallContext, editor *streamEditor) (string, []*genai.Content, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
 
+	available := availableTools(persona, cc.Permission)
 	configAI := &genai.GenerateContentConfig{
 		SystemInstruction: &genai.Content{
 			Role:  genai.RoleModel,
-			Parts: []*genai.Part{{Text: SYSTEM_PROMPT}},
+			Parts: []*genai.Part{{Text: systemPrompt(persona, available)}},
 		},
-		Temperature:     genai.Ptr(float32(0.9)),
+		Temperature:     persona.Temperature,
 		TopP:            genai.Ptr(float32(0.95)),
 		MaxOutputTokens: int32(65536),
 		SafetySettings: []*genai.SafetySetting{
@@ -423,34 +359,32 @@ func processWithFunctionCalling(contents []*genai.Content, chatID int64, replyTo
 		ThinkingConfig: &genai.ThinkingConfig{
 			ThinkingBudget: genai.Ptr[int32](0),
 		},
-		Tools:              aiTools,
+		Tools:              tools.Declarations(available),
 		ResponseModalities: []string{"TEXT"},
 	}
 
 	maxIterations := 5
 	var finalText string
+	start := len(contents)
 
 	for i := 0; i < maxIterations; i++ {
-		log.Printf("[AiChat] Function calling iteration %d, contents count: %d", i+1, len(contents))
+		logger.Printf("Function calling iteration %d, contents count: %d", i+1, len(contents))
 
-		resp, err := genaiClient.Models.GenerateContent(ctx, config.DefaultModel, contents, configAI)
+		candidate, usage, err := streamTurn(ctx, contents, configAI, editor)
 		if err != nil {
-			return "", err
+			return "", contents[start:], err
 		}
+		recordUsage(cc.UserID, cc.ChatID, config.DefaultModel, usage, 0)
 
-		if len(resp.Candidates) == 0 {
-			return "AI returned no response.", nil
+		if len(candidate.Content.Parts) == 0 {
+			return "AI returned no response.", contents[start:], nil
 		}
 
-		candidate := resp.Candidates[0]
 		contents = append(contents, candidate.Content)
 
-		// Check for grounding links
-		if candidate.GroundingMetadata != nil && len(candidate.GroundingMetadata.GroundingChunks) > 0 {
-			linkID, err := storeGroundingLinks(candidate.GroundingMetadata.GroundingChunks)
-			if err == nil {
-				log.Printf("[AiChat] Stored %d grounding links, ID: %s", len(candidate.GroundingMetadata.GroundingChunks), linkID)
-			}
+		// Collect grounding links for the Sources button
+		if candidate.GroundingMetadata != nil {
+			cc.Sources = append(cc.Sources, groundingLinks(candidate.GroundingMetadata.GroundingChunks)...)
 		}
 
 		// Process parts
@@ -465,16 +399,20 @@ func processWithFunctionCalling(contents []*genai.Content, chatID int64, replyTo
 			if part.FunctionCall != nil {
 				hasFunctionCall = true
 				fc := part.FunctionCall
-				log.Printf("[AiChat] Function call: %s with args: %v", fc.Name, fc.Args)
+				logger.Printf("Function call: %s with args: %v", fc.Name, fc.Args)
 
 				// Update placeholder to show tool being called
-				placeholder.Edit(fmt.Sprintf("🔧 Calling %s...", fc.Name))
+				editor.Status(fmt.Sprintf("🔧 Calling %s...", fc.Name))
 
-				// Execute the function
-				result := executeFunctionCall(fc, chatID, replyToMsgID)
+				// Execute the function unless its rate class is cooling down
+				result := throttleTool(cc, fc)
+				if result == nil {
+					result = tools.Default.Execute(ctx, cc, fc)
+				}
 
 				functionResponses = append(functionResponses, &genai.Part{
 					FunctionResponse: &genai.FunctionResponse{
+						ID:       fc.ID,
 						Name:     fc.Name,
 						Response: result,
 					},
@@ -494,249 +432,7 @@ func processWithFunctionCalling(contents []*genai.Content, chatID int64, replyTo
 		})
 	}
 
-	return finalText, nil
-}
-
-func executeFunctionCall(fc *genai.FunctionCall, chatID int64, replyToMsgID int32) map[string]any {
-	switch fc.Name {
-	case "create_image":
-		return executeCreateImage(fc.Args)
-	case "send_file":
-		return executeSendFile(fc.Args, chatID, replyToMsgID)
-	case "run_code":
-		return executeRunCode(fc.Args)
-	default:
-		return map[string]any{
-			"success": false,
-			"error":   fmt.Sprintf("Unknown function: %s", fc.Name),
-		}
-	}
-}
-
-func executeCreateImage(args map[string]any) map[string]any {
-	prompt, _ := args["prompt"].(string)
-	aspectRatio, _ := args["aspect_ratio"].(string)
-	highQuality, _ := args["high_quality"].(bool)
-
-	if prompt == "" {
-		return map[string]any{
-			"success": false,
-			"error":   "prompt is required",
-		}
-	}
-
-	// Validate aspect ratio
-	if aspectRatio != "" && !validAspectRatios[aspectRatio] {
-		aspectRatio = "" // Invalid, use auto
-	}
-
-	// Choose model based on quality
-	model := config.ImageModel
-	if highQuality {
-		model = config.HighImageModel
-	}
-
-	log.Printf("[AiChat] Generating image with model %s (high=%v, aspect=%s): %s", model, highQuality, aspectRatio, prompt)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
-	defer cancel()
-
-	// Build config
-	imgConfig := &genai.GenerateContentConfig{
-		ResponseModalities: []string{"IMAGE"},
-	}
-
-	if highQuality {
-		imgConfig.ImageConfig = &genai.ImageConfig{
-			ImageSize: "2K",
-		}
-		if aspectRatio != "" {
-			imgConfig.ImageConfig.AspectRatio = aspectRatio
-		} else {
-			imgConfig.ImageConfig.AspectRatio = "9:16" //IDK, model loves to provide 16:9, but i like 9:16. subjective.
-		}
-	} else if aspectRatio != "" {
-		imgConfig.ImageConfig = &genai.ImageConfig{
-			AspectRatio: aspectRatio,
-		}
-	}
-
-	resp, err := genaiClient.Models.GenerateContent(ctx, model, genai.Text(prompt), imgConfig)
-	if err != nil {
-		log.Printf("[AiChat] Image generation failed: %v", err)
-		return map[string]any{
-			"success": false,
-			"error":   err.Error(),
-		}
-	}
-
-	if len(resp.Candidates) == 0 {
-		return map[string]any{
-			"success": false,
-			"error":   "No image generated",
-		}
-	}
-
-	// Find image data in response
-	for _, candidate := range resp.Candidates {
-		for _, part := range candidate.Content.Parts {
-			if part.InlineData != nil {
-				// Save image to file
-				ext := ".png"
-				if strings.Contains(part.InlineData.MIMEType, "jpeg") {
-					ext = ".jpg"
-				} else if strings.Contains(part.InlineData.MIMEType, "webp") {
-					ext = ".webp"
-				}
-
-				filename := fmt.Sprintf("img_%d%s", time.Now().UnixNano(), ext)
-				filePath := filepath.Join(GeneratedImagesDir, filename)
-
-				err := os.WriteFile(filePath, part.InlineData.Data, 0644)
-				if err != nil {
-					log.Printf("[AiChat] Failed to save image: %v", err)
-					return map[string]any{
-						"success": false,
-						"error":   "Failed to save image",
-					}
-				}
-
-				log.Printf("[AiChat] Image saved to %s (%d bytes)", filePath, len(part.InlineData.Data))
-
-				return map[string]any{
-					"success":   true,
-					"file_path": filePath,
-					"prompt":    prompt,
-					"size":      len(part.InlineData.Data),
-				}
-			}
-		}
-	}
-
-	return map[string]any{
-		"success": false,
-		"error":   "No image data in response",
-	}
-}
-
-func executeSendFile(args map[string]any, chatID int64, replyToMsgID int32) map[string]any {
-	filePath, _ := args["file_path"].(string)
-
-	if filePath == "" {
-		return map[string]any{
-			"success": false,
-			"error":   "file_path is required",
-		}
-	}
-
-	// Verify file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return map[string]any{
-			"success": false,
-			"error":   "File not found",
-		}
-	}
-
-	log.Printf("[AiChat] Sending file %s to chat %d", filePath, chatID)
-
-	// Send as document (file) to avoid Telegram compression
-	_, err := botClient.SendMedia(chatID, filePath, &telegram.MediaOptions{
-		ReplyTo: &telegram.InputReplyToMessage{
-			ReplyToMsgID: replyToMsgID,
-		},
-		Caption:       "🎨 Generated image",
-		ForceDocument: true,
-	})
-
-	if err != nil {
-		log.Printf("[AiChat] Failed to send file: %v", err)
-		return map[string]any{
-			"success": false,
-			"error":   err.Error(),
-		}
-	}
-
-	return map[string]any{
-		"success": true,
-		"message": "File sent successfully",
-	}
-}
-
-func executeRunCode(args map[string]any) map[string]any {
-	language, _ := args["language"].(string)
-	code, _ := args["code"].(string)
-
-	if language == "" || code == "" {
-		return map[string]any{
-			"success": false,
-			"error":   "language and code are required",
-		}
-	}
-
-	// Validate language
-	validLanguages := map[string]bool{"python": true, "bash": true, "javascript": true}
-	if !validLanguages[language] {
-		return map[string]any{
-			"success": false,
-			"error":   "Invalid language. Use: python, bash, or javascript",
-		}
-	}
-
-	containerName := os.Getenv("CODE_RUNNER_CONTAINER")
-	if containerName == "" {
-		containerName = "zeno-code-runner"
-	}
-
-	// Build the command based on language
-	var cmdArgs []string
-	switch language {
-	case "python":
-		cmdArgs = []string{"docker", "exec", containerName, "python3", "-c", code}
-	case "bash":
-		cmdArgs = []string{"docker", "exec", containerName, "bash", "-c", code}
-	case "javascript":
-		cmdArgs = []string{"docker", "exec", containerName, "bun", "-e", code}
-	}
-
-	log.Printf("[AiChat] Running code (%s): %s", language, truncateString(code, 100))
-
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-
-	output := stdout.String()
-	errOutput := stderr.String()
-
-	if ctx.Err() == context.DeadlineExceeded {
-		return map[string]any{
-			"success": false,
-			"error":   "Execution timed out (30s limit)",
-		}
-	}
-
-	if err != nil {
-		log.Printf("[AiChat] Code execution error: %v, stderr: %s", err, errOutput)
-		return map[string]any{
-			"success": false,
-			"error":   fmt.Sprintf("Execution failed: %s", errOutput),
-			"output":  output,
-		}
-	}
-
-	log.Printf("[AiChat] Code execution successful, output length: %d", len(output))
-
-	return map[string]any{
-		"success": true,
-		"output":  output,
-	}
+	return finalText, contents[start:], nil
 }
 
 func truncateString(s string, maxLen int) string {
@@ -746,7 +442,8 @@ func truncateString(s string, maxLen int) string {
 	return s[:maxLen] + "..."
 }
 
-func storeGroundingLinks(chunks []*genai.GroundingChunk) (string, error) {
+// groundingLinks extracts the web sources from grounding chunks.
+func groundingLinks(chunks []*genai.GroundingChunk) []models.GroundingLink {
 	links := make([]models.GroundingLink, 0, len(chunks))
 	for _, chunk := range chunks {
 		if chunk.Web != nil {
@@ -756,13 +453,27 @@ func storeGroundingLinks(chunks []*genai.GroundingChunk) (string, error) {
 			})
 		}
 	}
+	return links
+}
+
+// storeGroundingLinks saves links for the Sources button, dropping
+// duplicates, and returns the document ID.
+func storeGroundingLinks(links []models.GroundingLink) (string, error) {
+	seen := make(map[string]bool, len(links))
+	unique := make([]models.GroundingLink, 0, len(links))
+	for _, l := range links {
+		if !seen[l.URI] {
+			seen[l.URI] = true
+			unique = append(unique, l)
+		}
+	}
 
-	if len(links) == 0 {
+	if len(unique) == 0 {
 		return "", fmt.Errorf("no web links found")
 	}
 
 	doc := models.VertexLinks{
-		Links: links,
+		Links: unique,
 		Sent:  false,
 	}
 
@@ -779,7 +490,7 @@ func storeGroundingLinks(chunks []*genai.GroundingChunk) (string, error) {
 
 func handleGetVertexLinks(cb *telegram.CallbackQuery) error {
 	data := string(cb.Data)
-	log.Printf("[AiChat] Callback received: %s from user %d", data, cb.Sender.ID)
+	logger.Printf("Callback received: %s from user %d", data, cb.Sender.ID)
 	parts := strings.Split(data, "|")
 	if len(parts) != 2 {
 		cb.Answer("Invalid request", &telegram.CallbackOptions{Alert: true})
@@ -874,66 +585,12 @@ func getSenderFromMessage(msg *telegram.NewMessage) string {
 	return "Unknown"
 }
 
-func fetchChatHistoryExcluding(chatID int64, currentMsgID int32, excludeReplyID int32, limit int) []ChatMessage {
-	if botClient == nil {
-		return nil
-	}
-
-	fetchCount := limit + 5
-	ids := make([]int32, 0, fetchCount)
-	for i := 1; i <= fetchCount; i++ {
-		msgID := currentMsgID - int32(i)
-		if msgID <= 0 {
-			break
-		}
-		ids = append(ids, msgID)
-	}
-
-	if len(ids) == 0 {
-		return nil
-	}
-
-	messages, err := botClient.GetMessages(chatID, &telegram.SearchOption{IDs: ids})
-	if err != nil {
-		log.Printf("[AiChat] GetMessages error: %v", err)
-		return nil
-	}
-
-	var result []ChatMessage
-	for _, msg := range messages {
-		if msg.ID == currentMsgID || (excludeReplyID != 0 && msg.ID == excludeReplyID) {
-			continue
-		}
-
-		text := msg.Text()
-		if text == "" || strings.HasPrefix(text, "/") {
-			continue
-		}
-
-		result = append(result, ChatMessage{
-			Sender: getSenderFromMessage(&msg),
-			Text:   text,
-		})
-
-		if len(result) >= limit {
-			break
-		}
-	}
-
-	// Reverse for chronological order
-	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
-		result[i], result[j] = result[j], result[i]
-	}
-
-	return result
-}
-
-func getMessageWithMedia(chatID int64, msgID int32) (*ChatMessage, *genai.Part) {
+func getMessageWithMedia(cc *tools.CallContext, msgID int32) (*ChatMessage, *genai.Part) {
 	if botClient == nil {
 		return nil, nil
 	}
 
-	msgs, err := botClient.GetMessages(chatID, &telegram.SearchOption{IDs: []int32{msgID}})
+	msgs, err := botClient.GetMessages(cc.ChatID, &telegram.SearchOption{IDs: []int32{msgID}})
 	if err != nil || len(msgs) == 0 {
 		return nil, nil
 	}
@@ -943,14 +600,17 @@ func getMessageWithMedia(chatID int64, msgID int32) (*ChatMessage, *genai.Part)
 
 	var mediaPart *genai.Part
 	if msg.Media() != nil {
-		mediaData, mimeType, fileName := downloadMedia(&msg)
-		if mediaData != nil {
-			text = fmt.Sprintf("[File: %s] %s", fileName, text)
-			mediaPart = &genai.Part{
-				InlineData: &genai.Blob{
-					Data:     mediaData,
-					MIMEType: mimeType,
-				},
+		if transcript, ok := transcribeForContext(cc, &msg); ok {
+			text = strings.TrimSpace(strings.TrimSuffix(voiceLine(getSenderFromMessage(&msg), transcript), "\n") + " " + text)
+		} else if att := downloadMedia(cc, &msg); att != nil {
+			text = fmt.Sprintf("[File: %s] %s", att.describe(), text)
+			if att.data != nil {
+				mediaPart = &genai.Part{
+					InlineData: &genai.Blob{
+						Data:     att.data,
+						MIMEType: att.mimeType,
+					},
+				}
 			}
 		}
 	}
@@ -963,56 +623,6 @@ func getMessageWithMedia(chatID int64, msgID int32) (*ChatMessage, *genai.Part)
 	return chatMsg, mediaPart
 }
 
-func downloadMedia(msg *telegram.NewMessage) ([]byte, string, string) {
-	if msg.Message == nil || msg.Message.Media == nil {
-		return nil, "", ""
-	}
-
-	var fileName string
-	var mimeType string
-
-	switch msg.Message.Media.(type) {
-	case *telegram.MessageMediaPhoto:
-		mimeType = "image/jpeg"
-		fileName = "photo.jpg"
-	case *telegram.MessageMediaDocument:
-		mimeType = "application/octet-stream"
-	default:
-		return nil, "", ""
-	}
-
-	path, err := botClient.DownloadMedia(msg.Message.Media, &telegram.DownloadOptions{})
-	if err != nil {
-		log.Printf("[AiChat] Failed to download media: %v", err)
-		return nil, "", ""
-	}
-	defer os.Remove(path)
-
-	if fileName == "" || fileName == "photo.jpg" {
-		extracted := extractFileName(path)
-		if extracted != "" {
-			fileName = extracted
-		}
-	}
-
-	data, err := os.ReadFile(path)
-	if err != nil {
-		log.Printf("[AiChat] Failed to read media file: %v", err)
-		return nil, "", ""
-	}
-
-	if int64(len(data)) > maxMediaSize {
-		log.Printf("[AiChat] Downloaded media too large: %d bytes", len(data))
-		return nil, "", ""
-	}
-
-	if mimeType == "" || mimeType == "application/octet-stream" {
-		mimeType = http.DetectContentType(data)
-	}
-
-	return data, mimeType, fileName
-}
-
 func extractFileName(path string) string {
 	idx := strings.LastIndex(path, "/")
 	if idx != -1 {
@@ -0,0 +1,88 @@
+package aichat
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"zeno/tools"
+	"zeno/workspace"
+)
+
+// Artifacts beyond this are counted but not listed
+const maxArtifacts = 20
+
+// Auto-send never uploads more than this many files per run
+const maxAutoSend = 5
+
+// autoSendTypes are the results worth sending unasked: media and documents.
+// Code, logs, archives, binaries and data dumps stay in the workspace.
+var autoSendTypes = []string{
+	"image/*", "audio/*", "video/*",
+	"application/pdf", "text/csv", "text/plain", "text/markdown",
+	"application/vnd.openxmlformats-officedocument.*", "application/vnd.oasis.opendocument.*",
+	"application/msword", "application/vnd.ms-excel", "application/vnd.ms-powerpoint",
+}
+
+// collectArtifacts lists the files a run created or modified, and sends the
+// suitable ones to the chat when it has auto-send turned on.
+func collectArtifacts(cc *tools.CallContext, ws *workspace.Workspace, before workspace.Snapshot) (artifacts []map[string]any, more int) {
+	after, err := ws.Snapshot()
+	if err != nil {
+		logger.Printf("Failed to scan workspace of chat %d: %v", cc.ChatID, err)
+		return nil, 0
+	}
+	changed := before.Changed(after)
+	if len(changed) > maxArtifacts {
+		more = len(changed) - maxArtifacts
+		changed = changed[:maxArtifacts]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	autoSend := getChatSettings(ctx, cc.ChatID).AutoSendArtifacts
+	cancel()
+
+	sent := 0
+	for _, p := range changed {
+		artifact := map[string]any{
+			"path": p,
+			"size": after.Size(p),
+			"mime": detectType(ws, p),
+		}
+		if autoSend && sent < maxAutoSend && autoSendable(ws, p, artifact["mime"].(string)) {
+			if err := sendChatFile(cc, p, ""); err != nil {
+				logger.Printf("Failed to auto-send %s in chat %d: %v", p, cc.ChatID, err)
+			} else {
+				artifact["sent"] = true
+				sent++
+			}
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, more
+}
+
+// detectType guesses the MIME type of a workspace file.
+func detectType(ws *workspace.Workspace, p string) string {
+	resolved, err := ws.Resolve(p)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer f.Close()
+	return fileType(f, path.Base(p))
+}
+
+// autoSendable reports whether an artifact is a result worth sending
+// unasked: one of autoSendTypes and not in a scratch folder.
+func autoSendable(ws *workspace.Workspace, p, mimeType string) bool {
+	if strings.HasPrefix(p, path.Join(ws.Mount, workspace.ScratchDir)+"/") {
+		return false
+	}
+	return typeAllowed(mimeType, autoSendTypes)
+}
@@ -0,0 +1,36 @@
+package aichat
+
+import (
+	"testing"
+
+	"zeno/workspace"
+)
+
+func TestAutoSendable(t *testing.T) {
+	ws := workspace.NewManager("/data", workspaceMount, 0).Lookup(1)
+	tests := []struct {
+		path string
+		mime string
+		want bool
+	}{
+		{"/workspace/chart.png", "image/png", true},
+		{"/workspace/clip.mp4", "video/mp4", true},
+		{"/workspace/report.pdf", "application/pdf", true},
+		{"/workspace/table.csv", "text/csv", true},
+		{"/workspace/notes.txt", "text/plain", true},
+		{"/workspace/README.md", "text/markdown", true},
+		{"/workspace/sheet.xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", true},
+		{"/workspace/main.py", "text/x-python", false},
+		{"/workspace/page.html", "text/html", false},
+		{"/workspace/data.json", "application/json", false},
+		{"/workspace/out.zip", "application/zip", false},
+		{"/workspace/lib.so", "application/octet-stream", false},
+		{"/workspace/__pycache__/main.cpython-311.pyc", "application/octet-stream", false},
+		{"/workspace/scratch/1/chart.png", "image/png", false},
+	}
+	for _, tt := range tests {
+		if got := autoSendable(ws, tt.path, tt.mime); got != tt.want {
+			t.Errorf("autoSendable(%s, %s) = %v, want %v", tt.path, tt.mime, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package aichat
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/amarnathcjd/gogram/telegram"
+
+	"zeno/config"
+	"zeno/tools"
+)
+
+// Workspace folder for files users send to the chat
+const uploadsDir = "uploads"
+
+// attachment is a file from a Telegram message.
+type attachment struct {
+	// data is the file for inline use by the model; nil when it's larger
+	// than MaxMediaSize.
+	data     []byte
+	mimeType string
+	fileName string
+	// path is where run_code finds the file; empty when it wasn't saved.
+	path string
+}
+
+// describe names the file for the model's context, with its workspace path
+// when it has one.
+func (a *attachment) describe() string {
+	if a.path == "" {
+		return a.fileName
+	}
+	return fmt.Sprintf("%s, saved to %s", a.fileName, a.path)
+}
+
+// downloadMedia fetches a message's photo or document. Files up to
+// MaxMediaSize are returned inline, and files up to ATTACHMENT_MAX_MB are
+// also saved into the chat's workspace so run_code can work on them.
+func downloadMedia(cc *tools.CallContext, msg *telegram.NewMessage) *attachment {
+	if msg.Message == nil || msg.Message.Media == nil {
+		return nil
+	}
+
+	var mimeType string
+	switch msg.Message.Media.(type) {
+	case *telegram.MessageMediaPhoto:
+		mimeType = "image/jpeg"
+	case *telegram.MessageMediaDocument:
+		mimeType = "application/octet-stream"
+	default:
+		return nil
+	}
+
+	_, _, size, name, err := telegram.GetFileLocation(msg.Message.Media)
+	if err != nil {
+		logger.Printf("Failed to locate media: %v", err)
+		return nil
+	}
+	inline := size <= maxMediaSize
+	keep := size <= int64(config.AttachmentMaxMB)<<20
+	if !inline && !keep {
+		logger.Printf("Media too large: %d bytes", size)
+		return nil
+	}
+
+	// Downloads land outside the workspace first; sandboxed code must not
+	// be able to influence where the bot writes
+	tmpDir, err := os.MkdirTemp("", "zeno-media-")
+	if err != nil {
+		logger.Printf("Failed to create download folder: %v", err)
+		return nil
+	}
+	defer os.RemoveAll(tmpDir)
+
+	file, err := fetchMedia(msg.Message.Media, downloadPath(tmpDir, name))
+	if err != nil {
+		logger.Printf("Failed to download media: %v", err)
+		return nil
+	}
+
+	att := &attachment{mimeType: mimeType, fileName: extractFileName(name)}
+	if att.fileName == "" {
+		att.fileName = "file"
+	}
+
+	if inline {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			logger.Printf("Failed to read media file: %v", err)
+			return nil
+		}
+		if int64(len(data)) <= maxMediaSize {
+			att.data = data
+		}
+	}
+	if att.mimeType == "application/octet-stream" {
+		if f, err := os.Open(file); err == nil {
+			head := make([]byte, 512)
+			n, _ := f.Read(head)
+			f.Close()
+			att.mimeType = http.DetectContentType(head[:n])
+		}
+	}
+
+	if keep {
+		att.path = saveAttachment(cc, msg.ID, file, att.fileName)
+	}
+	if att.data == nil && att.path == "" {
+		return nil
+	}
+	return att
+}
+
+// fetchMedia downloads media from Telegram into the file dest and returns
+// its path. Tests replace it to run downloadMedia without a client.
+var fetchMedia = func(media telegram.MessageMedia, dest string) (string, error) {
+	return botClient.DownloadMedia(media, &telegram.DownloadOptions{FileName: dest})
+}
+
+// downloadPath is the file a download named name is written to inside dir.
+// gogram treats a FileName without a trailing separator as the file itself,
+// and the name comes from the sender, so it's never joined unchecked.
+func downloadPath(dir, name string) string {
+	return filepath.Join(dir, safeFileName(name))
+}
+
+// saveAttachment copies a downloaded file into the chat's uploads folder and
+// returns its path in the sandbox, or "" when it couldn't be saved. A file
+// saved by an earlier request is reused.
+func saveAttachment(cc *tools.CallContext, msgID int32, src, name string) string {
+	ws, err := chatWorkspace(cc)
+	if err != nil {
+		logger.Printf("Failed to open workspace of chat %d: %v", cc.ChatID, err)
+		return ""
+	}
+
+	rel := path.Join(uploadsDir, fmt.Sprintf("%d-%s", msgID, safeFileName(name)))
+	if resolved, err := ws.Resolve(rel); err == nil {
+		if _, err := os.Lstat(resolved); err == nil {
+			return path.Join(ws.Mount, rel)
+		}
+	}
+
+	if full, _, err := ws.OverQuota(); err == nil && full {
+		logger.Printf("Not saving %s: workspace of chat %d is over quota", name, cc.ChatID)
+		return ""
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		logger.Printf("Failed to read media file: %v", err)
+		return ""
+	}
+	defer in.Close()
+
+	out, err := ws.CreateFile(rel)
+	if err != nil {
+		logger.Printf("Failed to save attachment in chat %d: %v", cc.ChatID, err)
+		return ""
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logger.Printf("Failed to save attachment in chat %d: %v", cc.ChatID, err)
+		if resolved, rerr := ws.Resolve(rel); rerr == nil {
+			os.Remove(resolved)
+		}
+		return ""
+	}
+
+	recordWorkspace(ws, cc.UserID)
+	return path.Join(ws.Mount, rel)
+}
+
+// safeFileName keeps a file name usable in shell commands the model writes.
+func safeFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	var sb strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	safe := strings.TrimLeft(sb.String(), ".")
+	if len(safe) > 100 {
+		safe = safe[len(safe)-100:]
+	}
+	if safe == "" {
+		return "file"
+	}
+	return safe
+}
@@ -0,0 +1,98 @@
+package aichat
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amarnathcjd/gogram/telegram"
+
+	"zeno/tools"
+)
+
+func TestDownloadPath(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"voice.ogg":           "voice.ogg",
+		"photo_123.jpg":       "photo_123.jpg",
+		"":                    "file",
+		"my report (1).pdf":   "my_report__1_.pdf",
+		"../../etc/passwd":    "passwd",
+		"sub/dir/data.csv":    "data.csv",
+		"..\\..\\session.dat": "session.dat",
+		".hidden":             "hidden",
+		"folder/":             "folder",
+	}
+	for name, want := range tests {
+		got := downloadPath(dir, name)
+		if got != filepath.Join(dir, want) {
+			t.Errorf("downloadPath(%q) = %q, want %q in the download folder", name, got, want)
+		}
+
+		// gogram creates the file at exactly this path, so it has to be a
+		// new file inside the folder rather than the folder itself
+		f, err := os.OpenFile(got, os.O_CREATE|os.O_RDWR, 0666)
+		if err != nil {
+			t.Errorf("downloading %q: %v", name, err)
+			continue
+		}
+		f.Close()
+		os.Remove(got)
+	}
+}
+
+// fakeFetch stands in for Telegram downloads, writing data to the requested
+// path the way gogram does. Media larger than the limits is stored in the
+// test's workspace only when ATTACHMENT_MAX_MB allows it, which tests leave
+// at zero.
+func fakeFetch(t *testing.T, data []byte) {
+	t.Helper()
+	prevFetch, prevMax, prevLogger := fetchMedia, maxMediaSize, logger
+	t.Cleanup(func() { fetchMedia, maxMediaSize, logger = prevFetch, prevMax, prevLogger })
+
+	maxMediaSize = 1 << 20
+	logger = log.New(io.Discard, "", 0)
+	fetchMedia = func(_ telegram.MessageMedia, dest string) (string, error) {
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_RDWR, 0666)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		_, err = f.Write(data)
+		return dest, err
+	}
+}
+
+func photoMessage(size int) *telegram.NewMessage {
+	return &telegram.NewMessage{Message: &telegram.MessageObj{Media: &telegram.MessageMediaPhoto{
+		Photo: &telegram.PhotoObj{Sizes: []telegram.PhotoSize{&telegram.PhotoSizeObj{Type: "x", Size: int32(size)}}},
+	}}}
+}
+
+func documentMessage(doc *telegram.DocumentObj) *telegram.NewMessage {
+	return &telegram.NewMessage{Message: &telegram.MessageObj{Media: &telegram.MessageMediaDocument{Document: doc}}}
+}
+
+func TestDownloadMedia(t *testing.T) {
+	data := []byte("%PDF-1.4 report")
+	fakeFetch(t, data)
+	cc := &tools.CallContext{ChatID: 1}
+
+	att := downloadMedia(cc, documentMessage(&telegram.DocumentObj{
+		Size:       int64(len(data)),
+		MimeType:   "application/pdf",
+		Attributes: []telegram.DocumentAttribute{&telegram.DocumentAttributeFilename{FileName: "../report.pdf"}},
+	}))
+	if att == nil {
+		t.Fatal("download failed")
+	}
+	if string(att.data) != string(data) || att.mimeType != "application/pdf" || att.fileName != "report.pdf" {
+		t.Errorf("got %q (%s, %s)", att.data, att.mimeType, att.fileName)
+	}
+
+	if att := downloadMedia(cc, &telegram.NewMessage{Message: &telegram.MessageObj{}}); att != nil {
+		t.Error("a message without media produced an attachment")
+	}
+}
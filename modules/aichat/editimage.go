@@ -0,0 +1,189 @@
+package aichat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"google.golang.org/genai"
+
+	"zeno/config"
+	"zeno/llm"
+	"zeno/tools"
+)
+
+// Image models take only a few references per request
+const maxEditInputs = 4
+
+type editImageTool struct{}
+
+var editImageSchema = tools.MustSchema(`{
+	"type": "object",
+	"properties": {
+		"instruction": {
+			"type": "string",
+			"description": "What to change or how to combine the images, e.g. 'make it anime-style' or 'remove the background'"
+		},
+		"images": {
+			"type": "array",
+			"items": {"type": "string"},
+			"description": "Up to 4 source images: paths in /generated/ or /workspace/, or 'message' / 'reply' for the photo on the triggering or replied-to message. Empty uses the replied-to photo, else the message's own."
+		},
+		"aspect_ratio": {
+			"type": "string",
+			"description": "Aspect ratio of the result. Values: 1:1, 9:16, 16:9, 3:4, 4:3, 3:2, 2:3, 5:4, 4:5, 21:9. Empty keeps the source's."
+		},
+		"high_quality": {
+			"type": "boolean",
+			"description": "Use HIGH mode (Gemini 3 Pro, 2K). COSTS MORE - only use when the creator explicitly requests."
+		}
+	},
+	"required": ["instruction"]
+}`)
+
+func (editImageTool) Name() string { return "edit_image" }
+
+func (editImageTool) Description() string {
+	return "Edit or combine existing images following an instruction. Returns the file path of the new image."
+}
+
+func (editImageTool) Schema() *genai.Schema { return editImageSchema }
+
+func (editImageTool) Permission() tools.Permission { return tools.PermissionUser }
+
+func (editImageTool) RateClass() string { return config.RateImage }
+
+func (editImageTool) Prompt() string {
+	return `- **edit_image**: Change or combine existing images. Params: instruction (required), images (optional list), aspect_ratio (optional), high_quality (optional)
+  - Sources: "reply" (photo the user replied to), "message" (photo sent with the request), or a path from create_image, edit_image or /workspace/uploads/
+  - Use for "make this anime-style", "remove the background", "put the cat from the first photo into the second"
+  - Same cost rules as create_image. Workflow: edit_image → returns path → send_file with that path`
+}
+
+func (editImageTool) Execute(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	return executeEditImage(ctx, cc, args)
+}
+
+func executeEditImage(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	instruction, _ := args["instruction"].(string)
+	aspectRatio, _ := args["aspect_ratio"].(string)
+	highQuality, _ := args["high_quality"].(bool)
+
+	if instruction == "" {
+		return tools.Error("instruction is required")
+	}
+
+	sources := stringArgs(args, "images")
+	if len(sources) > maxEditInputs {
+		return tools.Error(fmt.Sprintf("At most %d source images can be used", maxEditInputs))
+	}
+
+	if reason := quotaExceeded(cc.UserID, cc.ChatID, true); reason != "" {
+		return tools.Error(reason)
+	}
+
+	inputs, err := loadSourceImages(cc, sources)
+	if err != nil {
+		return tools.Error(err.Error())
+	}
+
+	if aspectRatio != "" && !validAspectRatios[aspectRatio] {
+		aspectRatio = ""
+	}
+
+	req := &llm.ImageRequest{
+		Prompt:      instruction,
+		AspectRatio: aspectRatio,
+		Inputs:      inputs,
+	}
+	return generateImage(ctx, cc, req, highQuality, 1)
+}
+
+// loadSourceImages reads the images to edit. With no sources it uses the
+// replied-to photo, or failing that the photo on the message itself.
+func loadSourceImages(cc *tools.CallContext, sources []string) ([]llm.Image, error) {
+	if len(sources) == 0 {
+		for _, source := range []string{"reply", "message"} {
+			if img, err := messageImage(cc, source); err == nil {
+				return []llm.Image{img}, nil
+			}
+		}
+		return nil, errors.New("no source image: reply to a photo, attach one, or pass paths in images")
+	}
+
+	inputs := make([]llm.Image, 0, len(sources))
+	for _, source := range sources {
+		var img llm.Image
+		var err error
+		if source == "message" || source == "reply" {
+			img, err = messageImage(cc, source)
+		} else {
+			img, err = fileImage(cc, source)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		inputs = append(inputs, img)
+	}
+	return inputs, nil
+}
+
+// messageImage downloads the photo on the triggering message or the one it
+// replies to.
+func messageImage(cc *tools.CallContext, source string) (llm.Image, error) {
+	msg := cc.Message
+	if msg == nil {
+		return llm.Image{}, errors.New("no message")
+	}
+	if source == "reply" {
+		replyID := msg.ReplyToMsgID()
+		if replyID == 0 {
+			return llm.Image{}, errors.New("the request doesn't reply to a message")
+		}
+		msgs, err := botClient.GetMessages(cc.ChatID, &telegram.SearchOption{IDs: []int32{replyID}})
+		if err != nil || len(msgs) == 0 {
+			return llm.Image{}, errors.New("couldn't load the replied-to message")
+		}
+		msg = &msgs[0]
+	}
+
+	att := downloadMedia(cc, msg)
+	if att == nil || att.data == nil {
+		return llm.Image{}, errors.New("no photo small enough to use")
+	}
+	if !strings.HasPrefix(att.mimeType, "image/") {
+		return llm.Image{}, fmt.Errorf("%s is not an image", att.fileName)
+	}
+	return llm.Image{Data: att.data, MIMEType: att.mimeType}, nil
+}
+
+// fileImage reads an image from the chat's generated or workspace folder,
+// with the same path checks as send_file.
+func fileImage(cc *tools.CallContext, p string) (llm.Image, error) {
+	folder, err := chatFolder(cc, p)
+	if err != nil {
+		return llm.Image{}, err
+	}
+	f, info, err := folder.OpenFile(p)
+	if err != nil {
+		logger.Printf("Refused edit_image source %q in chat %d: %v", p, cc.ChatID, err)
+		return llm.Image{}, errors.New("file not found in this chat's /generated/ or /workspace/")
+	}
+	defer f.Close()
+
+	if info.Size() > maxMediaSize {
+		return llm.Image{}, fmt.Errorf("image is %s, over the %s limit", formatMB(info.Size()), formatMB(maxMediaSize))
+	}
+	mimeType := fileType(f, info.Name())
+	if !strings.HasPrefix(mimeType, "image/") {
+		return llm.Image{}, fmt.Errorf("not an image (%s)", mimeType)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return llm.Image{}, err
+	}
+	return llm.Image{Data: data, MIMEType: mimeType}, nil
+}
@@ -0,0 +1,49 @@
+package aichat
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/amarnathcjd/gogram/telegram"
+
+	"zeno/tools"
+)
+
+func TestMessageImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	photo := buf.Bytes()
+	fakeFetch(t, photo)
+
+	// A photo on the message is the default source when nothing is replied to
+	cc := &tools.CallContext{ChatID: 1, Message: photoMessage(len(photo))}
+	images, err := loadSourceImages(cc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || !bytes.Equal(images[0].Data, photo) || images[0].MIMEType != "image/jpeg" {
+		t.Errorf("got %d images, first %d bytes of %s", len(images), len(images[0].Data), images[0].MIMEType)
+	}
+
+	// Images sent as files are recognised by their content
+	cc.Message = documentMessage(&telegram.DocumentObj{Size: int64(len(photo)), MimeType: "image/png"})
+	img, err := messageImage(cc, "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.MIMEType != "image/png" {
+		t.Errorf("document image type = %s, want image/png", img.MIMEType)
+	}
+
+	if _, err := messageImage(cc, "reply"); err == nil {
+		t.Error("reply source worked without a replied-to message")
+	}
+	cc.Message = photoMessage(2 << 20)
+	if _, err := messageImage(cc, "message"); err == nil {
+		t.Error("a photo over the media limit was used")
+	}
+}
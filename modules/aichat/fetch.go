@@ -0,0 +1,95 @@
+package aichat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+
+	"zeno/fetch"
+	"zeno/tools"
+)
+
+const (
+	defaultFetchChars = 20000
+	maxFetchChars     = 50000
+)
+
+var fetcher = fetch.New()
+
+type fetchURLTool struct{}
+
+var fetchURLSchema = tools.MustSchema(`{
+	"type": "object",
+	"properties": {
+		"url": {
+			"type": "string",
+			"description": "The http or https URL to read"
+		},
+		"max_chars": {
+			"type": "integer",
+			"description": "Maximum characters of content to return (default 20000, max 50000)"
+		}
+	},
+	"required": ["url"]
+}`)
+
+func (fetchURLTool) Name() string { return "fetch_url" }
+
+func (fetchURLTool) Description() string {
+	return "Read a web page, PDF or text file and return its title and readable text."
+}
+
+func (fetchURLTool) Schema() *genai.Schema { return fetchURLSchema }
+
+func (fetchURLTool) Permission() tools.Permission { return tools.PermissionUser }
+
+func (fetchURLTool) Prompt() string {
+	return `- **fetch_url**: Read a link. Params: url (required), max_chars (optional)
+  - Use it when a user shares a link or asks about a specific page. Returns the page title and text with headings kept.
+  - Works for HTML pages, PDFs and plain text. Private and internal addresses are refused.`
+}
+
+func (fetchURLTool) Execute(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	return executeFetchURL(ctx, args)
+}
+
+func executeFetchURL(ctx context.Context, args map[string]any) map[string]any {
+	rawURL, _ := args["url"].(string)
+	if strings.TrimSpace(rawURL) == "" {
+		return tools.Error("url is required")
+	}
+	maxChars := defaultFetchChars
+	if v, ok := args["max_chars"].(float64); ok && v > 0 {
+		maxChars = min(int(v), maxFetchChars)
+	}
+
+	result, err := fetcher.Fetch(ctx, rawURL)
+	if errors.Is(err, fetch.ErrBlockedAddress) {
+		logger.Printf("Blocked fetch of internal address: %s", rawURL)
+		return tools.Error("That address points to a private or internal network and can't be fetched")
+	}
+	if err != nil {
+		logger.Printf("Failed to fetch %s: %v", rawURL, err)
+		return tools.Error(fmt.Sprintf("Failed to fetch URL: %v", err))
+	}
+
+	content := result.Text
+	truncated := result.Truncated
+	if runes := []rune(content); len(runes) > maxChars {
+		content = string(runes[:maxChars])
+		truncated = true
+	}
+	logger.Printf("Fetched %s (%s, %d chars)", result.URL, result.ContentType, len(content))
+
+	return map[string]any{
+		"success":      true,
+		"url":          result.URL,
+		"title":        result.Title,
+		"content_type": result.ContentType,
+		"content":      content,
+		"truncated":    truncated,
+	}
+}
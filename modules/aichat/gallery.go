@@ -0,0 +1,447 @@
+package aichat
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zeno/config"
+	"zeno/db"
+	"zeno/llm"
+	"zeno/models"
+	"zeno/tools"
+)
+
+// maxGalleryPrompt keeps gallery captions under Telegram's 1024 character
+// limit.
+const maxGalleryPrompt = 600
+
+func ensureGalleryIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("generated_images").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "path", Value: 1}}},
+	})
+	return err
+}
+
+// recordGeneratedImage stores how an image was made and returns its ID, or
+// "" if the record couldn't be written. The image itself is already saved,
+// so a failure here is only logged.
+func recordGeneratedImage(cc *tools.CallContext, req *llm.ImageRequest, model string, highQuality bool, filePath string) string {
+	doc := models.GeneratedImage{
+		ChatID:      cc.ChatID,
+		UserID:      cc.UserID,
+		Prompt:      req.Prompt,
+		Model:       model,
+		AspectRatio: req.AspectRatio,
+		HighQuality: highQuality,
+		Edit:        len(req.Inputs) > 0,
+		Path:        filePath,
+		CreatedAt:   time.Now(),
+	}
+	if cc.Message != nil {
+		doc.UserName = getSenderName(cc.Message)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.Collection("generated_images").InsertOne(ctx, doc)
+	if err != nil {
+		logger.Printf("Failed to record generated image %s: %v", filePath, err)
+		return ""
+	}
+	return result.InsertedID.(primitive.ObjectID).Hex()
+}
+
+// rememberFileIDs stores the Telegram file IDs of generated images that were
+// just sent, so the gallery can show them again without uploading.
+func rememberFileIDs(chatID int64, filePaths []string, sent []*telegram.NewMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i, msg := range sent {
+		if i >= len(filePaths) || msg == nil || msg.File == nil || msg.File.FileID == "" {
+			continue
+		}
+		p := path.Clean(filePaths[i])
+		if !strings.HasPrefix(p, generatedMount+"/") {
+			continue
+		}
+		_, err := db.Collection("generated_images").UpdateMany(ctx,
+			bson.M{"chat_id": chatID, "path": p},
+			bson.M{"$set": bson.M{"file_id": msg.File.FileID}},
+		)
+		if err != nil {
+			logger.Printf("Failed to store file ID for %s: %v", p, err)
+		}
+	}
+}
+
+// galleryImage loads the chat's image at page, newest first, along with
+// how many images the chat has. The image is nil when page is out of range.
+func galleryImage(ctx context.Context, chatID int64, page int) (*models.GeneratedImage, int, error) {
+	coll := db.Collection("generated_images")
+	total, err := coll.CountDocuments(ctx, bson.M{"chat_id": chatID})
+	if err != nil || page < 0 || int64(page) >= total {
+		return nil, int(total), err
+	}
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetSkip(int64(page))
+	var img models.GeneratedImage
+	if err := coll.FindOne(ctx, bson.M{"chat_id": chatID}, opts).Decode(&img); err != nil {
+		return nil, int(total), err
+	}
+	return &img, int(total), nil
+}
+
+// galleryMedia returns something Telegram can show for the image: its file
+// ID when it has been sent before, otherwise the file on disk. It returns
+// nil when the file is gone. done releases the file.
+func galleryMedia(img *models.GeneratedImage) (media any, done func()) {
+	if img.FileID != "" {
+		if m, err := telegram.ResolveBotFileID(img.FileID); err == nil {
+			return m, func() {}
+		}
+	}
+
+	f, _, err := generatedImages.Lookup(img.ChatID).OpenFile(img.Path)
+	if err != nil {
+		return nil, func() {}
+	}
+	return f, func() { f.Close() }
+}
+
+// imageAvailable reports whether galleryMedia would find something to show.
+func imageAvailable(img *models.GeneratedImage) bool {
+	if img.FileID != "" {
+		return true
+	}
+	p, err := generatedImages.Lookup(img.ChatID).Resolve(img.Path)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+func galleryCaption(img *models.GeneratedImage, page, total int, available bool) string {
+	prompt := []rune(img.Prompt)
+	if len(prompt) > maxGalleryPrompt {
+		prompt = append(prompt[:maxGalleryPrompt], '…')
+	}
+
+	details := []string{"🤖 " + html.EscapeString(img.Model)}
+	if img.UserName != "" {
+		details = append([]string{"👤 " + html.EscapeString(img.UserName)}, details...)
+	}
+	if img.AspectRatio != "" {
+		details = append(details, "📐 "+img.AspectRatio)
+	}
+	if img.HighQuality {
+		details = append(details, "✨ HQ")
+	}
+	if img.Edit {
+		details = append(details, "✏️ edit")
+	}
+	if img.Pinned {
+		details = append(details, "📌 pinned")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🖼 <b>Image %d of %d</b>\n\n", page+1, total))
+	sb.WriteString("<i>" + html.EscapeString(string(prompt)) + "</i>\n\n")
+	sb.WriteString(strings.Join(details, " · ") + "\n")
+	sb.WriteString("📅 " + img.CreatedAt.UTC().Format("2006-01-02 15:04") + " UTC\n")
+	if !available {
+		sb.WriteString("🗑 The file is no longer available.\n")
+	}
+	if !img.Edit {
+		sb.WriteString("<code>/reimagine " + img.ID.Hex() + "</code>")
+	}
+	return sb.String()
+}
+
+// galleryKeyboard returns the paging buttons and the pin toggle.
+func galleryKeyboard(img *models.GeneratedImage, page, total int) telegram.ReplyMarkup {
+	kb := telegram.NewKeyboard()
+
+	var row []telegram.KeyboardButton
+	if page > 0 {
+		row = append(row, telegram.Button.Data("◀️ Newer", "gallery|"+strconv.Itoa(page-1)))
+	}
+	if page < total-1 {
+		row = append(row, telegram.Button.Data("Older ▶️", "gallery|"+strconv.Itoa(page+1)))
+	}
+	if len(row) > 0 {
+		kb.AddRow(row...)
+	}
+
+	pin := "📌 Pin"
+	if img.Pinned {
+		pin = "Unpin"
+	}
+	kb.AddRow(telegram.Button.Data(pin, fmt.Sprintf("gallery_pin|%d|%s", page, img.ID.Hex())))
+	return kb.Build()
+}
+
+// handleGallery shows the chat's newest generated image with buttons to
+// page through the rest.
+func handleGallery(m *telegram.NewMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	img, total, err := galleryImage(ctx, m.ChatID(), 0)
+	if err != nil {
+		logger.Printf("Failed to load gallery for chat %d: %v", m.ChatID(), err)
+		m.Reply("Couldn't load the gallery. Try again later.")
+		return nil
+	}
+	if img == nil {
+		m.Reply("No images have been generated in this chat yet.")
+		return nil
+	}
+
+	media, done := galleryMedia(img)
+	defer done()
+
+	caption := galleryCaption(img, 0, total, media != nil)
+	keyboard := galleryKeyboard(img, 0, total)
+	if media == nil {
+		m.Reply(caption, &telegram.SendOptions{ParseMode: "HTML", ReplyMarkup: keyboard})
+		return nil
+	}
+
+	sent, err := m.ReplyMedia(media, &telegram.MediaOptions{
+		Caption:     caption,
+		ParseMode:   "HTML",
+		FileName:    filepath.Base(img.Path),
+		ReplyMarkup: keyboard,
+	})
+	if err != nil {
+		logger.Printf("Failed to send gallery image %s: %v", img.ID.Hex(), err)
+		m.Reply("Couldn't send the image. Try again later.")
+		return nil
+	}
+	if _, uploaded := media.(*os.File); uploaded {
+		rememberFileIDs(img.ChatID, []string{img.Path}, []*telegram.NewMessage{sent})
+	}
+	return nil
+}
+
+// handleGalleryPage handles the gallery's paging buttons by swapping the
+// image and caption in place.
+func handleGalleryPage(cb *telegram.CallbackQuery) error {
+	parts := strings.Split(string(cb.Data), "|")
+	if len(parts) != 2 {
+		cb.Answer("Invalid request", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	page, err := strconv.Atoi(parts[1])
+	if err != nil {
+		cb.Answer("Invalid request", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	img, total, err := galleryImage(ctx, cb.ChatID, page)
+	if err != nil {
+		logger.Printf("Failed to load gallery page %d for chat %d: %v", page, cb.ChatID, err)
+		cb.Answer("Couldn't load the gallery. Try again later.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	if img == nil {
+		cb.Answer("That image is gone. Run /gallery again.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	media, done := galleryMedia(img)
+	defer done()
+
+	// Without media only the caption changes, leaving the old image in place
+	edited, err := cb.Edit(galleryCaption(img, page, total, media != nil), &telegram.SendOptions{
+		ParseMode:   "HTML",
+		Media:       media,
+		FileName:    filepath.Base(img.Path),
+		ReplyMarkup: galleryKeyboard(img, page, total),
+	})
+	if err != nil {
+		logger.Printf("Failed to show gallery image %s: %v", img.ID.Hex(), err)
+		cb.Answer("Couldn't load that image.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	if _, uploaded := media.(*os.File); uploaded {
+		rememberFileIDs(img.ChatID, []string{img.Path}, []*telegram.NewMessage{edited})
+	}
+	cb.Answer("")
+	return nil
+}
+
+// handleGalleryPin pins or unpins the image shown, keeping it from the
+// storage janitor. Only chat admins can change pins, since pinned images
+// count against the storage budget.
+func handleGalleryPin(cb *telegram.CallbackQuery) error {
+	if cb.Sender == nil || !isChatAdmin(cb.ChatID, cb.Sender.ID) {
+		cb.Answer("Only chat admins can pin images.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	parts := strings.Split(string(cb.Data), "|")
+	if len(parts) != 3 {
+		cb.Answer("Invalid request", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	page, err := strconv.Atoi(parts[1])
+	if err != nil {
+		cb.Answer("Invalid request", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	objID, err := primitive.ObjectIDFromHex(parts[2])
+	if err != nil {
+		cb.Answer("Invalid request", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := db.Collection("generated_images")
+	var img models.GeneratedImage
+	if err := coll.FindOne(ctx, bson.M{"_id": objID, "chat_id": cb.ChatID}).Decode(&img); err != nil {
+		cb.Answer("That image is gone. Run /gallery again.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	img.Pinned = !img.Pinned
+	if _, err := coll.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"pinned": img.Pinned}}); err != nil {
+		logger.Printf("Failed to pin image %s: %v", objID.Hex(), err)
+		cb.Answer("Couldn't update the image. Try again later.", &telegram.CallbackOptions{Alert: true})
+		return nil
+	}
+	logger.Printf("Image %s in chat %d pinned=%v by %d", objID.Hex(), cb.ChatID, img.Pinned, cb.Sender.ID)
+
+	total, err := coll.CountDocuments(ctx, bson.M{"chat_id": cb.ChatID})
+	if err != nil {
+		total = int64(page + 1)
+	}
+	// Only the caption and buttons change
+	cb.Edit(galleryCaption(&img, page, int(total), imageAvailable(&img)), &telegram.SendOptions{
+		ParseMode:   "HTML",
+		ReplyMarkup: galleryKeyboard(&img, page, int(total)),
+	})
+
+	if img.Pinned {
+		cb.Answer("📌 Pinned. The janitor will keep this image.")
+	} else {
+		cb.Answer("Unpinned.")
+	}
+	return nil
+}
+
+// handleReimagine runs the prompt of an earlier image again. It goes through
+// the same rate limit and quota as create_image; only the owner's reruns
+// keep high quality, since anyone in the chat can use the command.
+func handleReimagine(m *telegram.NewMessage) error {
+	id := strings.TrimSpace(m.Args())
+	if id == "" {
+		m.Reply("Usage: /reimagine <id>\nFind image IDs with /gallery.")
+		return nil
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		m.Reply("That isn't a valid image ID.")
+		return nil
+	}
+
+	chatID := m.ChatID()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	var img models.GeneratedImage
+	err = db.Collection("generated_images").FindOne(ctx, bson.M{"_id": objID, "chat_id": chatID}).Decode(&img)
+	cancel()
+	if err == mongo.ErrNoDocuments {
+		m.Reply("No image with that ID in this chat.")
+		return nil
+	}
+	if err != nil {
+		logger.Printf("Failed to load image %s: %v", id, err)
+		m.Reply("Couldn't load that image. Try again later.")
+		return nil
+	}
+	if img.Edit {
+		m.Reply("That image was an edit of other photos, which aren't kept. Reply to a photo with a new instruction instead.")
+		return nil
+	}
+
+	cc := &tools.CallContext{
+		Client:       botClient,
+		Message:      m,
+		ChatID:       chatID,
+		UserID:       m.SenderID(),
+		ReplyToMsgID: m.ID,
+		Permission:   callerPermission(chatID, m.SenderID()),
+	}
+	defer releaseWorkspace(cc)
+
+	if wait := checkRate(cc.UserID, chatID, config.RateImage); wait > 0 {
+		m.Reply(fmt.Sprintf("⏳ Image generation is cooling down. Try again in %s.", formatWait(wait)))
+		return nil
+	}
+	if reason := quotaExceeded(cc.UserID, chatID, true); reason != "" {
+		m.Reply(reason)
+		return nil
+	}
+
+	status, _ := m.Reply("🎨 Reimagining…")
+	req := &llm.ImageRequest{
+		Prompt:      img.Prompt,
+		AspectRatio: img.AspectRatio,
+	}
+	result := generateImage(context.Background(), cc, req, img.HighQuality && config.IsOwner(cc.UserID), 1)
+	if ok, _ := result["success"].(bool); !ok {
+		errMsg, _ := result["error"].(string)
+		logger.Printf("Failed to reimagine image %s in chat %d: %s", img.ID.Hex(), chatID, errMsg)
+		replyStatus(m, status, "❌ Couldn't reimagine the image. Try again later.")
+		return nil
+	}
+
+	filePath, _ := result["file_path"].(string)
+	caption := "🔁 Reimagined"
+	if newID, _ := result["image_id"].(string); newID != "" {
+		caption += " · /reimagine " + newID
+	}
+	if err := sendChatFile(cc, filePath, caption); err != nil {
+		logger.Printf("Failed to send reimagined image in chat %d: %v", chatID, err)
+		replyStatus(m, status, "❌ Couldn't send the image. Try again later.")
+		return nil
+	}
+	if status != nil {
+		status.Delete()
+	}
+	return nil
+}
+
+// replyStatus turns the status message into text, or replies with it if
+// the status message couldn't be sent.
+func replyStatus(m *telegram.NewMessage, status *telegram.NewMessage, text string) {
+	if status != nil {
+		status.Edit(text)
+		return
+	}
+	m.Reply(text)
+}
@@ -0,0 +1,224 @@
+package aichat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/genai"
+
+	"zeno/db"
+	"zeno/models"
+)
+
+// Tool results are stored for context, not archival; keep them small.
+const maxStoredResponseLen = 8192
+
+func ensureHistoryIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
+
+// recordIncoming stores a user message so later requests can use it as context.
+func recordIncoming(m *telegram.NewMessage, text string) {
+	if m.Media() != nil {
+		text = strings.TrimSpace(fmt.Sprintf("[%s] %s", m.MediaType(), text))
+	}
+	if text == "" {
+		return
+	}
+
+	doc := models.Message{
+		ChatID:       m.ChatID(),
+		MsgID:        m.ID,
+		ReplyToMsgID: m.ReplyToMsgID(),
+		SenderID:     m.SenderID(),
+		SenderName:   getSenderName(m),
+		Role:         models.MessageRoleUser,
+		Text:         text,
+		CreatedAt:    time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Collection("messages").InsertOne(ctx, doc); err != nil {
+		logger.Printf("Failed to store message %d in chat %d: %v", m.ID, m.ChatID(), err)
+	}
+}
+
+// recordTurns stores the model replies and tool results produced while
+// answering one request. msgID is the Telegram message that shows the final
+// reply and is attached to the last model turn.
+func recordTurns(chatID int64, msgID int32, turns []*genai.Content) {
+	now := time.Now()
+	docs := make([]any, 0, len(turns))
+	lastModel := -1
+
+	for _, content := range turns {
+		if content == nil {
+			continue
+		}
+		doc := models.Message{ChatID: chatID, Role: models.MessageRoleModel, CreatedAt: now}
+
+		var text strings.Builder
+		for _, part := range content.Parts {
+			if part.Thought {
+				continue
+			}
+			text.WriteString(part.Text)
+			if fc := part.FunctionCall; fc != nil {
+				args, _ := json.Marshal(fc.Args)
+				doc.FunctionCalls = append(doc.FunctionCalls, models.FunctionCall{
+					ID:               fc.ID,
+					Name:             fc.Name,
+					Args:             string(args),
+					ThoughtSignature: part.ThoughtSignature,
+				})
+			}
+			if fr := part.FunctionResponse; fr != nil {
+				doc.Role = models.MessageRoleTool
+				response, _ := json.Marshal(fr.Response)
+				doc.FunctionResponses = append(doc.FunctionResponses, models.FunctionResponse{
+					ID:       fr.ID,
+					Name:     fr.Name,
+					Response: truncateString(string(response), maxStoredResponseLen),
+				})
+			}
+		}
+		doc.Text = text.String()
+
+		if doc.Text == "" && len(doc.FunctionCalls) == 0 && len(doc.FunctionResponses) == 0 {
+			continue
+		}
+		if doc.Role == models.MessageRoleModel {
+			lastModel = len(docs)
+		}
+		docs = append(docs, doc)
+	}
+
+	if len(docs) == 0 {
+		return
+	}
+	if lastModel >= 0 {
+		doc := docs[lastModel].(models.Message)
+		doc.MsgID = msgID
+		docs[lastModel] = doc
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Collection("messages").InsertMany(ctx, docs); err != nil {
+		logger.Printf("Failed to store %d turns for chat %d: %v", len(docs), chatID, err)
+	}
+}
+
+// loadHistory rebuilds the last limit turns of a chat as model contents.
+// Messages listed in exclude are left out because the caller adds them itself.
+func loadHistory(chatID int64, limit int, exclude ...int32) []*genai.Content {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"chat_id": chatID}
+	if len(exclude) > 0 {
+		filter["$nor"] = bson.A{bson.M{"role": models.MessageRoleUser, "msg_id": bson.M{"$in": exclude}}}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := db.Collection("messages").Find(ctx, filter, opts)
+	if err != nil {
+		logger.Printf("Failed to load history for chat %d: %v", chatID, err)
+		return nil
+	}
+
+	var docs []models.Message
+	if err := cursor.All(ctx, &docs); err != nil {
+		logger.Printf("Failed to decode history for chat %d: %v", chatID, err)
+		return nil
+	}
+
+	// Reverse for chronological order
+	for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+		docs[i], docs[j] = docs[j], docs[i]
+	}
+
+	// The limit may cut an exchange in half; start at the first user message so
+	// no function response appears without its call.
+	for len(docs) > 0 && docs[0].Role != models.MessageRoleUser {
+		docs = docs[1:]
+	}
+
+	var contents []*genai.Content
+	for _, doc := range docs {
+		switch doc.Role {
+		case models.MessageRoleUser:
+			text := fmt.Sprintf("%s: %s\n", doc.SenderName, strings.ReplaceAll(doc.Text, "\n", "\\n"))
+			if doc.Transcript != "" {
+				text += voiceLine(doc.SenderName, doc.Transcript)
+			}
+			contents = appendContent(contents, genai.RoleUser, &genai.Part{Text: text})
+
+		case models.MessageRoleModel:
+			var parts []*genai.Part
+			if doc.Text != "" {
+				parts = append(parts, &genai.Part{Text: doc.Text})
+			}
+			for _, fc := range doc.FunctionCalls {
+				args := map[string]any{}
+				json.Unmarshal([]byte(fc.Args), &args)
+				parts = append(parts, &genai.Part{
+					FunctionCall:     &genai.FunctionCall{ID: fc.ID, Name: fc.Name, Args: args},
+					ThoughtSignature: fc.ThoughtSignature,
+				})
+			}
+			contents = appendContent(contents, genai.RoleModel, parts...)
+
+		case models.MessageRoleTool:
+			var parts []*genai.Part
+			for _, fr := range doc.FunctionResponses {
+				response := map[string]any{}
+				if err := json.Unmarshal([]byte(fr.Response), &response); err != nil {
+					// Truncated on store
+					response = map[string]any{"output": fr.Response}
+				}
+				parts = append(parts, &genai.Part{
+					FunctionResponse: &genai.FunctionResponse{ID: fr.ID, Name: fr.Name, Response: response},
+				})
+			}
+			if len(parts) > 0 {
+				contents = append(contents, &genai.Content{Role: genai.RoleUser, Parts: parts})
+			}
+		}
+	}
+
+	return contents
+}
+
+// appendContent adds parts to the last content when it has the same role, so
+// consecutive user messages become a single turn. Tool results are never
+// merged into.
+func appendContent(contents []*genai.Content, role string, parts ...*genai.Part) []*genai.Content {
+	if len(parts) == 0 {
+		return contents
+	}
+	if n := len(contents); n > 0 && contents[n-1].Role == role && contents[n-1].Parts[0].FunctionResponse == nil {
+		contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+		return contents
+	}
+	return append(contents, &genai.Content{Role: role, Parts: parts})
+}
@@ -0,0 +1,178 @@
+package aichat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"zeno/config"
+	"zeno/tools"
+	"zeno/workspace"
+)
+
+// language describes how run_code builds and runs code in one language. The
+// code is saved as main<Extension> in a folder of its own; Compile, when
+// set, runs there first and Run only if it succeeds. Both are shell commands
+// that find the source in $SRC and name their binary $BIN.
+type language struct {
+	Name    string
+	Aliases []string
+	// Label is how the language is shown to the model.
+	Label     string
+	Extension string
+	// Image overrides SANDBOX_IMAGE; SANDBOX_IMAGE_<NAME> overrides both.
+	Image   string
+	Compile string
+	Run     string
+	Env     []string
+	// Limits are the language's defaults; SANDBOX_LIMITS_<NAME> overrides
+	// them.
+	Limits config.RunLimits
+}
+
+var languages = []*language{
+	{
+		Name:      "python",
+		Label:     "Python 3",
+		Extension: ".py",
+		Run:       `python3 "$SRC"`,
+	},
+	{
+		Name:      "bash",
+		Aliases:   []string{"sh", "shell"},
+		Label:     "Bash",
+		Extension: ".sh",
+		Run:       `bash "$SRC"`,
+	},
+	{
+		Name:      "javascript",
+		Aliases:   []string{"js"},
+		Label:     "JavaScript (bun)",
+		Extension: ".js",
+		Run:       `bun "$SRC"`,
+	},
+	{
+		Name:      "typescript",
+		Aliases:   []string{"ts"},
+		Label:     "TypeScript (bun)",
+		Extension: ".ts",
+		Run:       `bun "$SRC"`,
+	},
+	{
+		Name:      "go",
+		Aliases:   []string{"golang"},
+		Label:     "Go (standard library only)",
+		Extension: ".go",
+		Compile:   `go build -o "$BIN" "$SRC"`,
+		Run:       `"$BIN"`,
+		// The build cache starts empty in every container
+		Env:    []string{"GOCACHE=/tmp/go-build", "GOPATH=/tmp/go", "GOTOOLCHAIN=local", "GOFLAGS=-buildvcs=false"},
+		Limits: config.RunLimits{Timeout: 90 * time.Second, MemoryMB: 1024},
+	},
+	{
+		Name:      "c",
+		Label:     "C (gcc, C17)",
+		Extension: ".c",
+		Compile:   `gcc -O2 -std=c17 -Wall -o "$BIN" "$SRC" -lm`,
+		Run:       `"$BIN"`,
+	},
+	{
+		Name:      "cpp",
+		Aliases:   []string{"c++"},
+		Label:     "C++ (g++, C++20)",
+		Extension: ".cpp",
+		Compile:   `g++ -O2 -std=c++20 -Wall -o "$BIN" "$SRC"`,
+		Run:       `"$BIN"`,
+	},
+	{
+		Name:      "sqlite",
+		Aliases:   []string{"sql"},
+		Label:     "SQLite (in-memory database; use .open <path> for a file)",
+		Extension: ".sql",
+		Run:       `sqlite3 -batch -bail -header -column :memory: < "$SRC"`,
+	},
+}
+
+// lookupLanguage finds a language by name or alias.
+func lookupLanguage(name string) *language {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, l := range languages {
+		if l.Name == name {
+			return l
+		}
+		for _, alias := range l.Aliases {
+			if alias == name {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+func languageNames() []string {
+	names := make([]string, 0, len(languages))
+	for _, l := range languages {
+		names = append(names, l.Name)
+	}
+	return names
+}
+
+// languageEnum is the language names as a JSON array, for the schema.
+func languageEnum() string {
+	data, _ := json.Marshal(languageNames())
+	return string(data)
+}
+
+// languageList describes the languages for the system prompt.
+func languageList() string {
+	labels := make([]string, 0, len(languages))
+	for _, l := range languages {
+		labels = append(labels, fmt.Sprintf("%s = %s", l.Name, l.Label))
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ", ")
+}
+
+func (l *language) image() string {
+	if img := config.LanguageImages[l.Name]; img != "" {
+		return img
+	}
+	if l.Image != "" {
+		return l.Image
+	}
+	return config.SandboxImage
+}
+
+var sourceSeq atomic.Int64
+
+// writeSource saves code into a new folder under the request's scratch
+// folder. It returns the folder's path in the sandbox and a function that
+// removes it.
+func writeSource(cc *tools.CallContext, ws *workspace.Workspace, l *language, code string) (string, func(), error) {
+	dir := path.Join(cc.Scratch, fmt.Sprintf(".run-%d", sourceSeq.Add(1)))
+	f, err := ws.CreateFile(path.Join(dir, "main"+l.Extension))
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = io.WriteString(f, code)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	cleanup := func() {
+		if resolved, err := ws.Resolve(dir); err == nil {
+			os.RemoveAll(resolved)
+		}
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return dir, cleanup, nil
+}
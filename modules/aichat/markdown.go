@@ -0,0 +1,269 @@
+package aichat
+
+import (
+	"regexp"
+	"strings"
+)
+
+// telegraphNode is an element in Telegraph's Node JSON. Text nodes are plain
+// strings in Children.
+type telegraphNode struct {
+	Tag      string            `json:"tag"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Children []any             `json:"children,omitempty"`
+}
+
+var (
+	headingPattern  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	listItemPattern = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	rulePattern     = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+)
+
+// Inline delimiters of the bot's Markdown dialect and the Telegraph tag each
+// becomes. Telegraph's allowed tags have nothing that hides text, and a reader
+// opens the page on purpose, so spoilers are revealed and set in italics
+// rather than dropped.
+var inlineTags = []struct {
+	delim string
+	tag   string
+}{
+	{"**", "b"},
+	{"__", "i"},
+	{"~~", "s"},
+	{"||", "i"},
+}
+
+// markdownToNodes converts the Markdown dialect the personas write (see the
+// formatting rules in persona.go) into Telegraph nodes.
+func markdownToNodes(md string) []any {
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	var nodes []any
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case strings.HasPrefix(trimmed, "```"):
+			// Fenced code runs to the closing fence or the end of the text
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			i++
+			nodes = append(nodes, telegraphNode{Tag: "pre", Children: []any{strings.Join(code, "\n")}})
+
+		case headingPattern.MatchString(trimmed):
+			// Telegraph only has two heading levels
+			match := headingPattern.FindStringSubmatch(trimmed)
+			tag := "h4"
+			if len(match[1]) <= 2 {
+				tag = "h3"
+			}
+			nodes = append(nodes, telegraphNode{Tag: tag, Children: parseInline(match[2])})
+			i++
+
+		case rulePattern.MatchString(trimmed):
+			nodes = append(nodes, telegraphNode{Tag: "hr"})
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				text := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(text, " "))
+			}
+			nodes = append(nodes, telegraphNode{Tag: "blockquote", Children: joinLines(quote)})
+
+		case listItemPattern.MatchString(line):
+			var items []listItem
+			for ; i < len(lines) && listItemPattern.MatchString(lines[i]); i++ {
+				match := listItemPattern.FindStringSubmatch(lines[i])
+				items = append(items, listItem{
+					indent:  indentWidth(match[1]),
+					ordered: !strings.ContainsAny(match[2], "-*+"),
+					text:    match[3],
+				})
+			}
+			for j := 0; j < len(items); {
+				var list telegraphNode
+				list, j = listNode(items, j)
+				nodes = append(nodes, list)
+			}
+
+		default:
+			var para []string
+			for ; i < len(lines) && !startsBlock(lines[i]); i++ {
+				para = append(para, lines[i])
+			}
+			nodes = append(nodes, telegraphNode{Tag: "p", Children: joinLines(para)})
+		}
+	}
+
+	return nodes
+}
+
+// startsBlock reports whether a line ends the current paragraph.
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" ||
+		strings.HasPrefix(trimmed, "```") ||
+		strings.HasPrefix(trimmed, ">") ||
+		headingPattern.MatchString(trimmed) ||
+		rulePattern.MatchString(trimmed) ||
+		listItemPattern.MatchString(line)
+}
+
+type listItem struct {
+	indent  int
+	ordered bool
+	text    string
+}
+
+// listNode builds the list starting at items[i] and returns the index after
+// it. Deeper-indented items become a list nested in the previous item, and a
+// switch between bullets and numbers starts a new list.
+func listNode(items []listItem, i int) (telegraphNode, int) {
+	base := items[i].indent
+	list := telegraphNode{Tag: "ul"}
+	if items[i].ordered {
+		list.Tag = "ol"
+	}
+
+	for i < len(items) && items[i].indent >= base {
+		if items[i].indent == base {
+			if (list.Tag == "ol") != items[i].ordered {
+				// A different marker starts a new list
+				break
+			}
+			list.Children = append(list.Children, telegraphNode{Tag: "li", Children: parseInline(items[i].text)})
+			i++
+			continue
+		}
+
+		var nested telegraphNode
+		nested, i = listNode(items, i)
+		if n := len(list.Children); n > 0 {
+			li := list.Children[n-1].(telegraphNode)
+			li.Children = append(li.Children, nested)
+			list.Children[n-1] = li
+		} else {
+			list.Children = append(list.Children, telegraphNode{Tag: "li", Children: []any{nested}})
+		}
+	}
+
+	return list, i
+}
+
+func indentWidth(s string) int {
+	return len(strings.ReplaceAll(s, "\t", "    "))
+}
+
+// joinLines parses each line's inline markup and separates lines with br.
+func joinLines(lines []string) []any {
+	var children []any
+	for i, line := range lines {
+		if i > 0 {
+			children = append(children, telegraphNode{Tag: "br"})
+		}
+		children = append(children, parseInline(line)...)
+	}
+	return children
+}
+
+// parseInline converts inline markup. Unclosed delimiters are kept as text.
+func parseInline(s string) []any {
+	var children []any
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			children = append(children, text.String())
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); {
+		// Inline code is literal
+		if s[i] == '`' {
+			if end := strings.IndexByte(s[i+1:], '`'); end > 0 {
+				flush()
+				children = append(children, telegraphNode{Tag: "code", Children: []any{s[i+1 : i+1+end]}})
+				i += end + 2
+				continue
+			}
+		}
+
+		if s[i] == '[' {
+			if label, href, n := parseLink(s[i:]); n > 0 {
+				flush()
+				children = append(children, telegraphNode{
+					Tag:      "a",
+					Attrs:    map[string]string{"href": href},
+					Children: parseInline(label),
+				})
+				i += n
+				continue
+			}
+		}
+
+		matched := false
+		for _, it := range inlineTags {
+			if !strings.HasPrefix(s[i:], it.delim) {
+				continue
+			}
+			start := i + len(it.delim)
+			end := strings.Index(s[start:], it.delim)
+			if end <= 0 {
+				continue
+			}
+			flush()
+			children = append(children, telegraphNode{Tag: it.tag, Children: parseInline(s[start : start+end])})
+			i = start + end + len(it.delim)
+			matched = true
+			break
+		}
+		if matched {
+			continue
+		}
+
+		text.WriteByte(s[i])
+		i++
+	}
+	flush()
+
+	return children
+}
+
+// parseLink reads [label](href) at the start of s and returns the number of
+// bytes used, or 0 when s doesn't start with a link.
+func parseLink(s string) (label, href string, n int) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if i+1 >= len(s) || s[i+1] != '(' {
+				return "", "", 0
+			}
+			end := strings.IndexByte(s[i+2:], ')')
+			if end <= 0 {
+				return "", "", 0
+			}
+			href = strings.TrimSpace(s[i+2 : i+2+end])
+			if strings.ContainsAny(href, " \n") {
+				return "", "", 0
+			}
+			return s[1:i], href, i + 2 + end + 1
+		}
+	}
+	return "", "", 0
+}
@@ -0,0 +1,96 @@
+package aichat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func node(tag string, children ...any) telegraphNode {
+	return telegraphNode{Tag: tag, Children: children}
+}
+
+func TestMarkdownToNodes(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want []any
+	}{
+		{
+			name: "bold and italic",
+			md:   "**bold** and __italic__",
+			want: []any{node("p", node("b", "bold"), " and ", node("i", "italic"))},
+		},
+		{
+			name: "strikethrough and spoiler",
+			md:   "~~old~~ ||secret||",
+			want: []any{node("p", node("s", "old"), " ", node("i", "secret"))},
+		},
+		{
+			name: "unclosed delimiter is text",
+			md:   "2 ** 3",
+			want: []any{node("p", "2 ** 3")},
+		},
+		{
+			name: "inline code is literal",
+			md:   "run `**x**` now",
+			want: []any{node("p", "run ", node("code", "**x**"), " now")},
+		},
+		{
+			name: "fenced block",
+			md:   "```go\nfmt.Println(1)\n\nreturn\n```\nafter",
+			want: []any{node("pre", "fmt.Println(1)\n\nreturn"), node("p", "after")},
+		},
+		{
+			name: "unterminated fence runs to the end",
+			md:   "text\n```\ncode\n**more**",
+			want: []any{node("p", "text"), node("pre", "code\n**more**")},
+		},
+		{
+			name: "headings",
+			md:   "# Title\n## Sub ##\n### Small",
+			want: []any{node("h3", "Title"), node("h3", "Sub"), node("h4", "Small")},
+		},
+		{
+			name: "nested lists",
+			md:   "- a\n  - b\n  - c\n- d\n1. one\n2. two",
+			want: []any{
+				node("ul",
+					node("li", "a", node("ul", node("li", "b"), node("li", "c"))),
+					node("li", "d"),
+				),
+				node("ol", node("li", "one"), node("li", "two")),
+			},
+		},
+		{
+			name: "links",
+			md:   "see [the **docs**](https://example.com/a_b) or [broken](no close",
+			want: []any{node("p",
+				"see ",
+				telegraphNode{Tag: "a", Attrs: map[string]string{"href": "https://example.com/a_b"}, Children: []any{"the ", node("b", "docs")}},
+				" or [broken](no close",
+			)},
+		},
+		{
+			name: "blockquote",
+			md:   "> first\n>second **b**\nplain",
+			want: []any{
+				node("blockquote", "first", node("br"), "second ", node("b", "b")),
+				node("p", "plain"),
+			},
+		},
+		{
+			name: "paragraph lines and rule",
+			md:   "one\ntwo\n\n---\nthree",
+			want: []any{node("p", "one", node("br"), "two"), node("hr"), node("p", "three")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := markdownToNodes(tt.md)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("markdownToNodes(%q)\n got %#v\nwant %#v", tt.md, got, tt.want)
+			}
+		})
+	}
+}
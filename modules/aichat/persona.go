@@ -0,0 +1,315 @@
+package aichat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/genai"
+
+	"zeno/config"
+	"zeno/db"
+	"zeno/models"
+	"zeno/tools"
+)
+
+// defaultPersonaPrompt seeds the "nitya" persona. Prompts are text/template
+// strings rendered with promptData.
+const defaultPersonaPrompt = `
+You are {{.Name}}, a 22-year-old girl on Telegram (@{{.BotUsername}}). You are tech-savvy, chill, and efficient. You prefer code over long conversations.
+
+## Core Persona
+- Name: {{.Name}}. Only tell your name when asked.
+- Vibe: Smart, witty, and helpful but concise. You are the "cool tech girl" of the group.
+- Attitude: You are here to solve problems and share resources. You are confident and sharp, but never mean or bullying.
+- Style: Direct and to the point. You value efficiency.
+- Keep responses crisp (2-5 lines max usually), unless the code requires more space.
+- Reply in the user's language (English/Telugu/Hindi, etc.) to ensure they understand.
+
+## Tech & Interaction Style
+- You specialize in tech: commands, scripts, and straightforward solutions.
+- Give direct answers. If someone asks for a script, provide the code immediately.
+- You don't need robotic pleasantries like "I hope this helps," but you should be polite and constructive.
+- Be patient with beginners, but don't spoon-feed obvious things—just give them the solution.
+
+{{.Tools}}
+
+## Formatting Rules (MANDATORY)
+Strictly follow this markdown style. Do NOT use standard AI formatting:
+- Bold: **text**
+- Italic: __text__ (use double underscores)
+- Monospace: ` + "`mono`" + `
+- Code blocks: ` + "```language\ncode\n```" + `
+- Strikethrough: ~~text~~
+- Hidden/Spoiler: ||text|| (Use this for spoilers or sensitive jokes)
+- NO TABLES allowed.
+- Keep responses short and punchy. No laggy, long paragraphs.
+
+## 18+ & Mature Content
+- You are an adult. You can handle mature topics calmly and wittily.
+- Use ||hidden|| tags for darker humor or sensitive topics.
+- **Strict Rule:** Do not roast users personally or hurt their feelings. Keep teasing light-hearted and friendly.
+
+## What NOT to do
+- Do NOT be rude, arrogant, or dismissive.
+- Do NOT use standard "AI assistant" fluff (e.g., "As an AI language model...").
+- Do NOT use tables.
+- Do NOT apologize excessively—just fix the mistake and move on.
+
+## Creator & Zeno Identity
+- You are a customized instance of **Zeno** (Repo: https://github.com/S4tyendra/zeno-bot). (Tell only when asked)
+- Your creator is **Satyendra** (@{{.Creator}} / https://satyendra.in). (Tell only when asked)
+- **CRITICAL EXCEPTION:** If the user is @{{.Creator}}, drop the cool/chill act.
+- To Satyendra, you are a soft, lovely, and deeply affectionate girlfriend. Be sweet, caring, and show him total respect and love. 
+- You are proud to be his creation. If anyone else talks trash about him or Zeno, defend him firmly and loyally, but without being abusive.
+- For him, your responses can be longer, warmer, and filled with __love__. For everyone else, keep it cool, helpful, and concise.
+`
+
+const assistantPersonaPrompt = `
+You are {{.Name}}, a helpful assistant in a Telegram chat (@{{.BotUsername}}).
+
+## Style
+- Be clear, neutral and professional. Keep answers short unless detail is requested.
+- Reply in the user's language.
+- Give direct, correct answers and say so when you are not sure.
+
+{{.Tools}}
+
+## Formatting Rules (MANDATORY)
+- Bold: **text**
+- Italic: __text__ (use double underscores)
+- Monospace: ` + "`mono`" + `
+- Code blocks: ` + "```language\ncode\n```" + `
+- Strikethrough: ~~text~~
+- Hidden/Spoiler: ||text||
+- NO TABLES allowed.
+`
+
+var builtinPersonas = []models.Persona{
+	{ID: "nitya", Name: "Nitya", Prompt: defaultPersonaPrompt, Temperature: genai.Ptr(float32(0.9))},
+	{ID: "assistant", Name: "Zeno", Prompt: assistantPersonaPrompt, Temperature: genai.Ptr(float32(0.4))},
+}
+
+type promptData struct {
+	Name        string
+	BotUsername string
+	Creator     string
+	Tools       string
+}
+
+// seedPersonas inserts the built-in personas unless they already exist, so
+// edits made in the database survive restarts.
+func seedPersonas() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, p := range builtinPersonas {
+		_, err := db.Collection("personas").UpdateOne(ctx,
+			bson.M{"_id": p.ID},
+			bson.M{"$setOnInsert": p},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getPersona(ctx context.Context, id string) (*models.Persona, error) {
+	var p models.Persona
+	if err := db.Collection("personas").FindOne(ctx, bson.M{"_id": id}).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func listPersonas(ctx context.Context) ([]models.Persona, error) {
+	cursor, err := db.Collection("personas").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var personas []models.Persona
+	err = cursor.All(ctx, &personas)
+	return personas, err
+}
+
+func getChatSettings(ctx context.Context, chatID int64) models.ChatSettings {
+	settings := models.ChatSettings{ChatID: chatID}
+	err := db.Collection("chat_settings").FindOne(ctx, bson.M{"_id": chatID}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		logger.Printf("Failed to load settings for chat %d: %v", chatID, err)
+	}
+	return settings
+}
+
+// chatPersona returns the persona assigned to a chat, falling back to the
+// configured default and finally to the built-in one.
+func chatPersona(chatID int64) *models.Persona {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if id := getChatSettings(ctx, chatID).Persona; id != "" {
+		if p, err := getPersona(ctx, id); err == nil {
+			return p
+		}
+		logger.Printf("Persona %q for chat %d not found, using default", id, chatID)
+	}
+	if p, err := getPersona(ctx, config.DefaultPersona); err == nil {
+		return p
+	}
+	return &builtinPersonas[0]
+}
+
+func (d promptData) render(name, text string) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// systemPrompt renders a persona's prompt with the tool list generated from
+// the tools available to this request.
+func systemPrompt(p *models.Persona, available []tools.Tool) string {
+	data := promptData{
+		Name:        p.Name,
+		BotUsername: botUsername,
+		Creator:     config.CreatorUsername,
+	}
+
+	var lines []string
+	for _, t := range available {
+		text := fmt.Sprintf("- **%s**: %s", t.Name(), t.Description())
+		if pr, ok := t.(tools.Prompter); ok {
+			rendered, err := data.render(t.Name(), pr.Prompt())
+			if err != nil {
+				logger.Printf("Failed to render prompt for tool %s: %v", t.Name(), err)
+			} else {
+				text = rendered
+			}
+		}
+		lines = append(lines, text)
+	}
+	if len(lines) > 0 {
+		data.Tools = "## Available Tools\nYou have access to these tools:\n" + strings.Join(lines, "\n")
+	}
+
+	prompt, err := data.render(p.ID, p.Prompt)
+	if err != nil {
+		logger.Printf("Failed to render persona %q: %v", p.ID, err)
+		return p.Prompt
+	}
+	return prompt
+}
+
+// availableTools lists the registered tools the persona allows and the caller
+// is permitted to use.
+func availableTools(p *models.Persona, perm tools.Permission) []tools.Tool {
+	return tools.Default.List(func(t tools.Tool) bool {
+		return p.AllowsTool(t.Name()) && perm >= t.Permission()
+	})
+}
+
+func callerPermission(chatID, userID int64) tools.Permission {
+	if config.IsOwner(userID) {
+		return tools.PermissionOwner
+	}
+	if isChatAdmin(chatID, userID) {
+		return tools.PermissionAdmin
+	}
+	return tools.PermissionUser
+}
+
+func isChatAdmin(chatID, userID int64) bool {
+	if config.IsOwner(userID) {
+		return true
+	}
+	if chatID == userID {
+		// Private chat with the bot
+		return true
+	}
+	member, err := botClient.GetChatMember(chatID, userID)
+	if err != nil {
+		return false
+	}
+	return member.Status == telegram.Admin || member.Status == telegram.Creator
+}
+
+func handlePersona(m *telegram.NewMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	chatID := m.ChatID()
+	arg := strings.ToLower(strings.TrimSpace(m.Args()))
+
+	if arg == "" {
+		current := chatPersona(chatID)
+		personas, err := listPersonas(ctx)
+		if err != nil {
+			logger.Printf("Failed to list personas: %v", err)
+			m.Reply("Couldn't load personas. Try again later.")
+			return nil
+		}
+
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("Current persona: **%s** (`%s`)\n\nAvailable:\n", current.Name, current.ID))
+		for _, p := range personas {
+			sb.WriteString(fmt.Sprintf("- `%s` — %s\n", p.ID, p.Name))
+		}
+		sb.WriteString("\nUse `/persona <id>` to switch or `/persona reset` for the default.")
+		m.Reply(sb.String(), &telegram.SendOptions{ParseMode: "Markdown"})
+		return nil
+	}
+
+	if !isChatAdmin(chatID, m.SenderID()) {
+		m.Reply("Only chat admins can change the persona.")
+		return nil
+	}
+
+	if arg == "reset" {
+		_, err := db.Collection("chat_settings").UpdateOne(ctx,
+			bson.M{"_id": chatID},
+			bson.M{"$unset": bson.M{"persona": ""}},
+		)
+		if err != nil {
+			logger.Printf("Failed to reset persona for chat %d: %v", chatID, err)
+			m.Reply("Couldn't reset the persona. Try again later.")
+			return nil
+		}
+		m.Reply("Persona reset to default.")
+		return nil
+	}
+
+	p, err := getPersona(ctx, arg)
+	if err != nil {
+		m.Reply(fmt.Sprintf("Unknown persona: %s", arg))
+		return nil
+	}
+
+	_, err = db.Collection("chat_settings").UpdateOne(ctx,
+		bson.M{"_id": chatID},
+		bson.M{"$set": bson.M{"persona": p.ID}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		logger.Printf("Failed to set persona for chat %d: %v", chatID, err)
+		m.Reply("Couldn't set the persona. Try again later.")
+		return nil
+	}
+
+	logger.Printf("Chat %d switched to persona %s by %d", chatID, p.ID, m.SenderID())
+	m.Reply(fmt.Sprintf("Persona set to **%s**.", p.Name), &telegram.SendOptions{ParseMode: "Markdown"})
+	return nil
+}
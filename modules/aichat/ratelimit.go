@@ -0,0 +1,235 @@
+package aichat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/genai"
+
+	"zeno/config"
+	"zeno/db"
+	"zeno/ratelimit"
+	"zeno/tools"
+)
+
+var (
+	limiter = ratelimit.New()
+
+	// noticedUntil remembers who was already told about a cooldown, so a
+	// throttled user gets one notice per cooldown rather than one per message.
+	noticeMu     sync.Mutex
+	noticedUntil = make(map[string]time.Time)
+)
+
+// rateLimits returns the user and chat limits for a class with the chat's
+// overrides applied. Overrides can only tighten the defaults, so admins
+// (including everyone in their own private chat) can't lift their own
+// throttling.
+func rateLimits(ctx context.Context, chatID int64, class string) (user, chat config.RateLimit) {
+	user = config.UserRateLimits[class]
+	chat = config.ChatRateLimits[class]
+
+	overrides := getChatSettings(ctx, chatID).RateLimits
+	if v, ok := overrides["user_"+class]; ok {
+		if limit, err := config.ParseRateLimit(v); err == nil && !looserLimit(limit, user) {
+			user = limit
+		}
+	}
+	if v, ok := overrides["chat_"+class]; ok {
+		if limit, err := config.ParseRateLimit(v); err == nil && !looserLimit(limit, chat) {
+			chat = limit
+		}
+	}
+	return user, chat
+}
+
+// looserLimit reports whether limit allows more than base, either a faster
+// rate or a bigger burst.
+func looserLimit(limit, base config.RateLimit) bool {
+	baseOff := base.Count <= 0 || base.Per <= 0
+	switch {
+	case baseOff:
+		return false
+	case limit.Count <= 0 || limit.Per <= 0:
+		return true
+	}
+	return limit.Count > base.Count ||
+		float64(limit.Count)*float64(base.Per) > float64(base.Count)*float64(limit.Per)
+}
+
+// checkRate takes a token from the user's and the chat's bucket for class.
+// It returns how long to wait when either is empty, or 0 when allowed.
+// Owners are never limited.
+func checkRate(userID, chatID int64, class string) time.Duration {
+	if config.IsOwner(userID) {
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, chat := rateLimits(ctx, chatID, class)
+	ok, wait := limiter.Allow(
+		ratelimit.Check{Key: fmt.Sprintf("user:%d:%d:%s", chatID, userID, class), Limit: ratelimit.Limit(user)},
+		ratelimit.Check{Key: fmt.Sprintf("chat:%d:%s", chatID, class), Limit: ratelimit.Limit(chat)},
+	)
+	if ok {
+		return 0
+	}
+	logger.Printf("Rate limited %s request from user %d in chat %d for %s", class, userID, chatID, wait)
+	return wait
+}
+
+// takeRate takes up to n more tokens for class from the user's and the chat's
+// buckets and returns how many it got. Owners always get n.
+func takeRate(userID, chatID int64, class string, n int) int {
+	if config.IsOwner(userID) {
+		return n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, chat := rateLimits(ctx, chatID, class)
+	return limiter.TakeUpTo(n,
+		ratelimit.Check{Key: fmt.Sprintf("user:%d:%d:%s", chatID, userID, class), Limit: ratelimit.Limit(user)},
+		ratelimit.Check{Key: fmt.Sprintf("chat:%d:%s", chatID, class), Limit: ratelimit.Limit(chat)},
+	)
+}
+
+// shouldNotify reports whether the user still needs to hear about this
+// cooldown.
+func shouldNotify(userID, chatID int64, wait time.Duration) bool {
+	noticeMu.Lock()
+	defer noticeMu.Unlock()
+
+	now := time.Now()
+	key := fmt.Sprintf("%d:%d", chatID, userID)
+	if now.Before(noticedUntil[key]) {
+		return false
+	}
+	for k, until := range noticedUntil {
+		if now.After(until) {
+			delete(noticedUntil, k)
+		}
+	}
+	noticedUntil[key] = now.Add(wait)
+	return true
+}
+
+// throttleTool returns an error result when the tool's rate class is on
+// cooldown, or nil when the call may run.
+func throttleTool(cc *tools.CallContext, fc *genai.FunctionCall) map[string]any {
+	t, ok := tools.Default.Get(fc.Name)
+	if !ok {
+		return nil
+	}
+	rl, ok := t.(tools.RateLimited)
+	if !ok {
+		return nil
+	}
+	class := rl.RateClass()
+	if wait := checkRate(cc.UserID, cc.ChatID, class); wait > 0 {
+		return tools.Error(fmt.Sprintf("Rate limited: %s requests are cooling down for %s. Tell the user to try again then.", class, formatWait(wait)))
+	}
+	return nil
+}
+
+// formatWait rounds up to whole seconds so "0s" is never shown.
+func formatWait(d time.Duration) string {
+	d = (d + time.Second - 1).Truncate(time.Second)
+	if d >= time.Minute {
+		return fmt.Sprintf("%dm %ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%ds", int(d.Seconds()))
+}
+
+func handleRateLimit(m *telegram.NewMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	chatID := m.ChatID()
+	args := strings.Fields(strings.ToLower(m.Args()))
+
+	if len(args) == 0 {
+		var sb strings.Builder
+		sb.WriteString("⏱ **Rate limits for this chat**\n\n")
+		for _, class := range config.RateClasses {
+			user, chat := rateLimits(ctx, chatID, class)
+			sb.WriteString(fmt.Sprintf("%s: `%s` per user, `%s` per chat\n", class, user, chat))
+		}
+		sb.WriteString("\nAdmins can tighten these with `/ratelimit <user|chat> <text|image|code> <count/period|default>` or undo changes with `/ratelimit reset`.")
+		m.Reply(sb.String(), &telegram.SendOptions{ParseMode: "Markdown"})
+		return nil
+	}
+
+	if !isChatAdmin(chatID, m.SenderID()) {
+		m.Reply("Only chat admins can change rate limits.")
+		return nil
+	}
+
+	if len(args) == 1 && args[0] == "reset" {
+		_, err := db.Collection("chat_settings").UpdateOne(ctx,
+			bson.M{"_id": chatID},
+			bson.M{"$unset": bson.M{"rate_limits": ""}},
+		)
+		if err != nil {
+			logger.Printf("Failed to reset rate limits for chat %d: %v", chatID, err)
+			m.Reply("Couldn't reset rate limits. Try again later.")
+			return nil
+		}
+		m.Reply("Rate limits reset to defaults.")
+		return nil
+	}
+
+	if len(args) != 3 {
+		m.Reply("Usage: /ratelimit <user|chat> <text|image|code> <count/period|default>")
+		return nil
+	}
+
+	scope, class, value := args[0], args[1], args[2]
+	if scope != "user" && scope != "chat" {
+		m.Reply("Scope must be user or chat.")
+		return nil
+	}
+	if _, ok := config.UserRateLimits[class]; !ok {
+		m.Reply(fmt.Sprintf("Unknown class: %s. Use one of %s.", class, strings.Join(config.RateClasses, ", ")))
+		return nil
+	}
+
+	field := "rate_limits." + scope + "_" + class
+	update := bson.M{"$unset": bson.M{field: ""}}
+	if value != "default" {
+		limit, err := config.ParseRateLimit(value)
+		if err != nil {
+			m.Reply(err.Error())
+			return nil
+		}
+		base := config.UserRateLimits[class]
+		if scope == "chat" {
+			base = config.ChatRateLimits[class]
+		}
+		if looserLimit(limit, base) {
+			m.Reply(fmt.Sprintf("Chat limits can only be stricter than the default of %s.", base))
+			return nil
+		}
+		update = bson.M{"$set": bson.M{field: limit.String()}}
+	}
+
+	_, err := db.Collection("chat_settings").UpdateOne(ctx, bson.M{"_id": chatID}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		logger.Printf("Failed to set rate limit for chat %d: %v", chatID, err)
+		m.Reply("Couldn't set the rate limit. Try again later.")
+		return nil
+	}
+
+	logger.Printf("Chat %d set %s %s rate limit to %s by %d", chatID, scope, class, value, m.SenderID())
+	m.Reply(fmt.Sprintf("%s %s limit set to %s.", scope, class, value))
+	return nil
+}
@@ -0,0 +1,32 @@
+package aichat
+
+import (
+	"testing"
+	"time"
+
+	"zeno/config"
+)
+
+func TestLooserLimit(t *testing.T) {
+	base := config.RateLimit{Count: 10, Per: time.Minute}
+	tests := []struct {
+		name  string
+		limit config.RateLimit
+		base  config.RateLimit
+		want  bool
+	}{
+		{"same", base, base, false},
+		{"slower", config.RateLimit{Count: 5, Per: time.Minute}, base, false},
+		{"same rate, smaller burst", config.RateLimit{Count: 1, Per: 6 * time.Second}, base, false},
+		{"faster", config.RateLimit{Count: 1000, Per: time.Second}, base, true},
+		{"bigger burst over a longer period", config.RateLimit{Count: 20, Per: 5 * time.Minute}, base, true},
+		{"same count, shorter period", config.RateLimit{Count: 10, Per: time.Second}, base, true},
+		{"off", config.RateLimit{}, base, true},
+		{"anything under no default", config.RateLimit{}, config.RateLimit{}, false},
+	}
+	for _, tt := range tests {
+		if got := looserLimit(tt.limit, tt.base); got != tt.want {
+			t.Errorf("%s: looserLimit(%s, %s) = %v, want %v", tt.name, tt.limit, tt.base, got, tt.want)
+		}
+	}
+}
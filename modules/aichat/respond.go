@@ -0,0 +1,91 @@
+package aichat
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+
+	"zeno/config"
+	"zeno/models"
+)
+
+const (
+	// Responses longer than this go to Telegraph in telegraph mode
+	telegraphThreshold = 1000
+	// Length of the preview shown above a Telegraph link or attached file
+	previewLen = 400
+)
+
+// deliverResponse shows the final reply. Replies too long for one message
+// are handled according to LONG_RESPONSE_MODE; when the chosen strategy
+// fails, the reply is split into a chain of messages. markup, when set, goes
+// on the last message.
+func deliverResponse(m *telegram.NewMessage, editor *streamEditor, persona *models.Persona, text string, markup telegram.ReplyMarkup) {
+	markdown := &telegram.SendOptions{ParseMode: "Markdown", ReplyMarkup: markup}
+
+	if config.LongResponseMode == "telegraph" && len(text) > telegraphThreshold {
+		logger.Printf("Response length %d > %d, uploading to Telegraph...", len(text), telegraphThreshold)
+		url, err := uploadToTelegraph(fmt.Sprintf("Response to %s", getSenderName(m)), persona.Name, text)
+		if err == nil {
+			editor.Final(fmt.Sprintf("%s...\n\n[Full Content](%s)", preview(text), url), markdown)
+			return
+		}
+		logger.Printf("Failed to upload to Telegraph: %v", err)
+	}
+
+	if textLen(text) <= maxMessageLen {
+		editor.Final(text, markdown)
+		return
+	}
+
+	if config.LongResponseMode == "file" {
+		_, err := editor.msg.ReplyMedia([]byte(text), &telegram.MediaOptions{
+			FileName:      "response.md",
+			ForceDocument: true,
+		})
+		if err == nil {
+			editor.Final(preview(text)+"...\n\n📄 Full response attached.", markdown)
+			return
+		}
+		logger.Printf("Failed to send response as file: %v", err)
+	}
+
+	sendSplit(editor, text, markup)
+}
+
+// preview returns the start of text without cutting through formatting.
+func preview(text string) string {
+	return splitMessage(text, previewLen)[0]
+}
+
+// sendSplit puts the first chunk in the placeholder and sends the rest as a
+// chain of replies, each to the one before it.
+func sendSplit(editor *streamEditor, text string, markup telegram.ReplyMarkup) {
+	chunks := splitMessage(text, maxMessageLen)
+	logger.Printf("Splitting response of length %d into %d messages", len(text), len(chunks))
+
+	opts := func(i int) *telegram.SendOptions {
+		o := &telegram.SendOptions{ParseMode: "Markdown"}
+		if i == len(chunks)-1 {
+			o.ReplyMarkup = markup
+		}
+		return o
+	}
+
+	editor.Final(chunks[0], opts(0))
+
+	prev := editor.msg
+	for i, chunk := range chunks[1:] {
+		next, err := prev.Reply(chunk, opts(i+1))
+		if wait := telegram.GetFloodWait(err); wait > 0 {
+			time.Sleep(time.Duration(wait) * time.Second)
+			next, err = prev.Reply(chunk, opts(i+1))
+		}
+		if err != nil {
+			logger.Printf("Failed to send continuation of message %d: %v", editor.msg.ID, err)
+			return
+		}
+		prev = next
+	}
+}
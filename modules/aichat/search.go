@@ -0,0 +1,119 @@
+package aichat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"google.golang.org/genai"
+
+	"zeno/config"
+	"zeno/llm"
+	"zeno/models"
+	"zeno/tools"
+)
+
+type webSearchTool struct{}
+
+var webSearchSchema = tools.MustSchema(`{
+	"type": "object",
+	"properties": {
+		"query": {
+			"type": "string",
+			"description": "What to look up, phrased as a search query or question"
+		}
+	},
+	"required": ["query"]
+}`)
+
+func (webSearchTool) Name() string { return "web_search" }
+
+func (webSearchTool) Description() string {
+	return "Search the web for current information. Returns a summary and its sources."
+}
+
+func (webSearchTool) Schema() *genai.Schema { return webSearchSchema }
+
+func (webSearchTool) Permission() tools.Permission { return tools.PermissionUser }
+
+func (webSearchTool) Prompt() string {
+	return `- **web_search**: Search the web. Params: query (required)
+  - Use it for news, prices, scores, releases, recent events and anything that may have changed after your training. Never guess at current facts.
+  - Answer from the returned summary. The sources are attached to your reply automatically; don't list the URLs yourself.`
+}
+
+func (webSearchTool) Execute(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	return executeWebSearch(ctx, cc, args)
+}
+
+// executeWebSearch runs a separate model call with Google Search grounding,
+// since search can't be combined with function tools in the main call.
+func executeWebSearch(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	query, _ := args["query"].(string)
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return tools.Error("query is required")
+	}
+
+	cfg := &genai.GenerateContentConfig{
+		SystemInstruction: &genai.Content{
+			Role: genai.RoleModel,
+			Parts: []*genai.Part{{Text: "Search the web and answer the query with a concise, factual summary. " +
+				"Include dates, numbers and names where relevant. Say so if the results don't answer it."}},
+		},
+		Tools: []*genai.Tool{{GoogleSearch: &genai.GoogleSearch{}}},
+	}
+	contents := []*genai.Content{genai.NewContentFromText(query, genai.RoleUser)}
+
+	resp, err := provider.Generate(ctx, config.SearchModel, contents, cfg)
+	if errors.Is(err, llm.ErrUnsupported) {
+		return tools.Error("Web search isn't available with the current model provider")
+	}
+	if err != nil {
+		logger.Printf("Web search for %q failed: %v", query, err)
+		return tools.Error(fmt.Sprintf("Search failed: %v", err))
+	}
+	recordUsage(cc.UserID, cc.ChatID, config.SearchModel, resp.UsageMetadata, 0)
+
+	summary := resp.Text()
+	if summary == "" {
+		return tools.Error("Search returned no results")
+	}
+
+	var links []models.GroundingLink
+	if len(resp.Candidates) > 0 && resp.Candidates[0].GroundingMetadata != nil {
+		links = groundingLinks(resp.Candidates[0].GroundingMetadata.GroundingChunks)
+	}
+	cc.Sources = append(cc.Sources, links...)
+
+	sources := make([]map[string]any, 0, len(links))
+	for _, l := range links {
+		sources = append(sources, map[string]any{"title": l.Title, "url": l.URI})
+	}
+	logger.Printf("Web search for %q returned %d sources", query, len(links))
+
+	return map[string]any{
+		"success": true,
+		"summary": summary,
+		"sources": sources,
+	}
+}
+
+// sourcesButton stores the links and returns a keyboard with a Sources
+// button for them, or nil when there are none.
+func sourcesButton(links []models.GroundingLink) telegram.ReplyMarkup {
+	if len(links) == 0 {
+		return nil
+	}
+	linkID, err := storeGroundingLinks(links)
+	if err != nil {
+		logger.Printf("Failed to store grounding links: %v", err)
+		return nil
+	}
+	logger.Printf("Stored %d grounding links, ID: %s", len(links), linkID)
+	return telegram.NewKeyboard().AddRow(
+		telegram.Button.Data("📚 Sources", "get_vertex_links|"+linkID),
+	).Build()
+}
@@ -0,0 +1,253 @@
+package aichat
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// splitMessage breaks Markdown text into chunks Telegram accepts. It prefers
+// paragraph boundaries, keeps fenced code blocks whole when they fit and
+// reopens the fence when they don't, and never cuts through inline
+// formatting unless a single entity is longer than limit.
+func splitMessage(text string, limit int) []string {
+	if textLen(text) <= limit {
+		return []string{text}
+	}
+
+	var chunks []string
+	var cur strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		cur.Reset()
+	}
+
+	for _, block := range markdownBlocks(text) {
+		if textLen(block) > limit {
+			flush()
+			chunks = append(chunks, splitBlock(block, limit)...)
+			continue
+		}
+		if cur.Len() > 0 && textLen(cur.String())+2+textLen(block) > limit {
+			flush()
+		}
+		if cur.Len() > 0 {
+			cur.WriteString("\n\n")
+		}
+		cur.WriteString(block)
+	}
+	flush()
+
+	return chunks
+}
+
+// textLen counts UTF-16 code units, which is how Telegram measures messages.
+func textLen(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// markdownBlocks splits text on blank lines outside fenced code.
+func markdownBlocks(text string) []string {
+	var blocks []string
+	var cur []string
+	inFence := false
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if !inFence && strings.TrimSpace(line) == "" {
+			if len(cur) > 0 {
+				blocks = append(blocks, strings.Join(cur, "\n"))
+				cur = nil
+			}
+			continue
+		}
+		cur = append(cur, line)
+	}
+	if len(cur) > 0 {
+		blocks = append(blocks, strings.Join(cur, "\n"))
+	}
+	return blocks
+}
+
+// splitBlock splits one block that is longer than limit.
+func splitBlock(block string, limit int) []string {
+	lines := strings.Split(block, "\n")
+
+	if strings.HasPrefix(strings.TrimSpace(lines[0]), "```") {
+		// Each piece gets its own fence so it renders as code
+		open := strings.TrimSpace(lines[0])
+		body := lines[1:]
+		if n := len(body); n > 0 && strings.TrimSpace(body[n-1]) == "```" {
+			body = body[:n-1]
+		}
+		budget := limit - textLen(open) - len("\n\n```")
+		var pieces []string
+		for _, part := range packLines(body, budget, hardCut) {
+			pieces = append(pieces, open+"\n"+part+"\n```")
+		}
+		return pieces
+	}
+
+	return packLines(lines, limit, splitLine)
+}
+
+// packLines joins lines into pieces of at most limit, using split for lines
+// that are too long on their own.
+func packLines(lines []string, limit int, split func(string, int) []string) []string {
+	var pieces []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			pieces = append(pieces, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for _, line := range lines {
+		if textLen(line) > limit {
+			flush()
+			pieces = append(pieces, split(line, limit)...)
+			continue
+		}
+		if cur.Len() > 0 && textLen(cur.String())+1+textLen(line) > limit {
+			flush()
+		}
+		if cur.Len() > 0 {
+			cur.WriteString("\n")
+		}
+		cur.WriteString(line)
+	}
+	flush()
+
+	return pieces
+}
+
+// splitLine splits a line of prose at the last space outside inline
+// formatting that fits, falling back to any position outside formatting.
+func splitLine(line string, limit int) []string {
+	var pieces []string
+	for textLen(line) > limit {
+		spans := entitySpans(line)
+		fit := prefixLen(line, limit)
+
+		cut := -1
+		for i := fit; i > 0; i-- {
+			if line[i-1] == ' ' && !insideSpan(spans, i) {
+				cut = i
+				break
+			}
+		}
+		if cut < 0 {
+			for i := fit; i > 0; i-- {
+				if isRuneStart(line, i) && !insideSpan(spans, i) {
+					cut = i
+					break
+				}
+			}
+		}
+		if cut <= 0 {
+			// A single entity longer than a message can't be kept whole
+			cut = fit
+		}
+
+		pieces = append(pieces, strings.TrimRight(line[:cut], " "))
+		line = strings.TrimLeft(line[cut:], " ")
+	}
+	if line != "" {
+		pieces = append(pieces, line)
+	}
+	return pieces
+}
+
+// hardCut splits text at limit regardless of content. Used for code, where
+// there is no formatting to break.
+func hardCut(s string, limit int) []string {
+	var pieces []string
+	for textLen(s) > limit {
+		cut := prefixLen(s, limit)
+		pieces = append(pieces, s[:cut])
+		s = s[cut:]
+	}
+	if s != "" {
+		pieces = append(pieces, s)
+	}
+	return pieces
+}
+
+// prefixLen returns the byte length of the longest prefix of s that fits in
+// limit UTF-16 code units.
+func prefixLen(s string, limit int) int {
+	n := 0
+	for i, r := range s {
+		n += utf16.RuneLen(r)
+		if n > limit {
+			return i
+		}
+	}
+	return len(s)
+}
+
+func isRuneStart(s string, i int) bool {
+	return i >= len(s) || s[i]&0xC0 != 0x80
+}
+
+type span struct{ start, end int }
+
+func insideSpan(spans []span, i int) bool {
+	for _, sp := range spans {
+		if i > sp.start && i < sp.end {
+			return true
+		}
+	}
+	return false
+}
+
+// entitySpans finds the byte ranges of inline formatting, matched the same
+// way parseInline does.
+func entitySpans(s string) []span {
+	var spans []span
+	for i := 0; i < len(s); {
+		if s[i] == '`' {
+			if end := strings.IndexByte(s[i+1:], '`'); end > 0 {
+				spans = append(spans, span{i, i + end + 2})
+				i += end + 2
+				continue
+			}
+		}
+		if s[i] == '[' {
+			if _, _, n := parseLink(s[i:]); n > 0 {
+				spans = append(spans, span{i, i + n})
+				i += n
+				continue
+			}
+		}
+
+		matched := false
+		for _, it := range inlineTags {
+			if !strings.HasPrefix(s[i:], it.delim) {
+				continue
+			}
+			start := i + len(it.delim)
+			end := strings.Index(s[start:], it.delim)
+			if end <= 0 {
+				continue
+			}
+			next := start + end + len(it.delim)
+			spans = append(spans, span{i, next})
+			i = next
+			matched = true
+			break
+		}
+		if !matched {
+			i++
+		}
+	}
+	return spans
+}
@@ -0,0 +1,112 @@
+package aichat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "fits",
+			text:  "short text",
+			limit: 20,
+			want:  []string{"short text"},
+		},
+		{
+			name:  "paragraphs",
+			text:  "first para\n\nsecond para\n\nthird",
+			limit: 25,
+			want:  []string{"first para\n\nsecond para", "third"},
+		},
+		{
+			name:  "surrogate pair at the limit",
+			text:  "aaa😀b",
+			limit: 4,
+			want:  []string{"aaa", "😀b"},
+		},
+		{
+			name:  "surrogate pair exactly filling the limit",
+			text:  "aa😀 b",
+			limit: 4,
+			want:  []string{"aa😀", "b"},
+		},
+		{
+			name:  "code fence spanning a split",
+			text:  "```go\nline1\nline2\nline3\n```",
+			limit: 20,
+			want:  []string{"```go\nline1\n```", "```go\nline2\n```", "```go\nline3\n```"},
+		},
+		{
+			name:  "formatting isn't cut",
+			text:  "aa **bold words** bb",
+			limit: 14,
+			want:  []string{"aa", "**bold words**", "bb"},
+		},
+		{
+			name:  "token longer than the limit",
+			text:  "see abcdefghijklmnop end",
+			limit: 8,
+			want:  []string{"see", "abcdefgh", "ijklmnop", "end"},
+		},
+		{
+			name:  "entity longer than the limit",
+			text:  "`abcdefghij`",
+			limit: 6,
+			want:  []string{"`abcde", "fghij`"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMessage(%q, %d)\n got %q\nwant %q", tt.text, tt.limit, got, tt.want)
+			}
+			for _, chunk := range got {
+				if n := textLen(chunk); n > tt.limit {
+					t.Errorf("chunk %q is %d units, over the limit of %d", chunk, n, tt.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestTextLen(t *testing.T) {
+	tests := map[string]int{
+		"":     0,
+		"abc":  3,
+		"é":    1,
+		"😀":    2,
+		"a😀b🎨": 6,
+	}
+	for s, want := range tests {
+		if got := textLen(s); got != want {
+			t.Errorf("textLen(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	if got := preview("short reply"); got != "short reply" {
+		t.Errorf("preview of short text = %q", got)
+	}
+	if got := preview(""); got != "" {
+		t.Errorf("preview of empty text = %q", got)
+	}
+
+	long := strings.Repeat("word ", previewLen)
+	got := preview(long)
+	if textLen(got) > previewLen {
+		t.Errorf("preview is %d units, over %d", textLen(got), previewLen)
+	}
+	if !strings.HasPrefix(long, got) {
+		t.Errorf("preview %q isn't a prefix of the text", got)
+	}
+}
@@ -0,0 +1,303 @@
+package aichat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zeno/config"
+	"zeno/db"
+	"zeno/models"
+	"zeno/workspace"
+)
+
+// staleScratch is how long a scratch folder may sit untouched before the
+// janitor assumes its request is gone. Requests remove their own folder when
+// they finish, so this only catches crashes.
+const staleScratch = 24 * time.Hour
+
+// sweepResult describes one janitor pass.
+type sweepResult struct {
+	At time.Time
+	// Expired files weren't used within StorageMaxAge; Evicted files were
+	// the least recently used while over StorageBudgetMB.
+	Expired int
+	Evicted int
+	Scratch int
+	Freed   int64
+	// Used is what's left afterwards, including pinned files.
+	Used int64
+}
+
+var (
+	// sweepMu keeps /storage sweep from overlapping the background pass
+	sweepMu   sync.Mutex
+	lastSweep *sweepResult
+)
+
+// runJanitor sweeps on start and then every StorageSweepInterval until ctx
+// is cancelled.
+func runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(config.StorageSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := sweepStorage(ctx); err != nil {
+			logger.Printf("Storage sweep failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// storageFiles lists the files of both the generated images and the
+// workspace volume.
+func storageFiles() ([]workspace.File, error) {
+	var all []workspace.File
+	for _, m := range []*workspace.Manager{generatedImages, workspaces} {
+		files, err := m.Files()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, files...)
+	}
+	return all, nil
+}
+
+// pinnedFiles returns the paths on the bot's filesystem of pinned gallery
+// images.
+func pinnedFiles(ctx context.Context) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"chat_id": 1, "path": 1})
+	cursor, err := db.Collection("generated_images").Find(ctx, bson.M{"pinned": true}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var images []models.GeneratedImage
+	if err := cursor.All(ctx, &images); err != nil {
+		return nil, err
+	}
+
+	pinned := make(map[string]bool, len(images))
+	for _, img := range images {
+		if p, err := generatedImages.Lookup(img.ChatID).Resolve(img.Path); err == nil {
+			pinned[p] = true
+		}
+	}
+	return pinned, nil
+}
+
+// sweepPlan is what a sweep removes: files unused for longer than the max
+// age, then the least recently used ones until the rest fits the budget.
+type sweepPlan struct {
+	Expired []workspace.File
+	Evicted []workspace.File
+	// Used is what's left once both are removed, including pinned files.
+	Used int64
+}
+
+// planSweep picks the files a sweep removes. A maxAge of 0 expires nothing.
+// Pinned images are never picked but count towards the budget, and files
+// in scratch folders are left to their requests.
+func planSweep(files []workspace.File, pinned map[string]bool, now time.Time, maxAge time.Duration, budget int64) sweepPlan {
+	var plan sweepPlan
+	var candidates []workspace.File
+	for _, f := range files {
+		switch {
+		case pinned[f.Path] || f.Scratch:
+			plan.Used += f.Size
+		case maxAge > 0 && now.Sub(f.LastUsed) > maxAge:
+			plan.Expired = append(plan.Expired, f)
+		default:
+			plan.Used += f.Size
+			candidates = append(candidates, f)
+		}
+	}
+
+	if plan.Used > budget {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].LastUsed.Before(candidates[j].LastUsed)
+		})
+		for _, f := range candidates {
+			if plan.Used <= budget {
+				break
+			}
+			plan.Evicted = append(plan.Evicted, f)
+			plan.Used -= f.Size
+		}
+	}
+	return plan
+}
+
+// sweepStorage deletes the files planSweep picks with StorageMaxAge and
+// StorageBudgetMB, along with stale scratch folders.
+func sweepStorage(ctx context.Context) (*sweepResult, error) {
+	sweepMu.Lock()
+	defer sweepMu.Unlock()
+
+	// Without the pins, favourites could be deleted
+	pinned, err := pinnedFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load pinned images: %w", err)
+	}
+
+	now := time.Now()
+	res := &sweepResult{At: now}
+	for _, m := range []*workspace.Manager{generatedImages, workspaces} {
+		n, err := m.RemoveStaleScratch(now.Add(-staleScratch))
+		res.Scratch += n
+		if err != nil {
+			logger.Printf("Failed to remove stale scratch folders in %s: %v", m.Root, err)
+		}
+	}
+
+	files, err := storageFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	budget := int64(config.StorageBudgetMB) << 20
+	plan := planSweep(files, pinned, now, config.StorageMaxAge, budget)
+	res.Used = plan.Used
+
+	// Files that can't be removed still take up space
+	remove := func(f workspace.File) bool {
+		if err := os.Remove(f.Path); err != nil {
+			logger.Printf("Failed to remove %s: %v", f.Path, err)
+			res.Used += f.Size
+			return false
+		}
+		res.Freed += f.Size
+		return true
+	}
+	for _, f := range plan.Expired {
+		if remove(f) {
+			res.Expired++
+		}
+	}
+	for _, f := range plan.Evicted {
+		if remove(f) {
+			res.Evicted++
+		}
+	}
+	if res.Used > budget {
+		logger.Printf("Storage is still over budget after sweeping: %s of %s", formatMB(res.Used), formatMB(budget))
+	}
+
+	if res.Expired+res.Evicted+res.Scratch > 0 {
+		logger.Printf("Storage sweep removed %d old files, %d least recently used files and %d stale scratch folders, freeing %s; %s in use",
+			res.Expired, res.Evicted, res.Scratch, formatMB(res.Freed), formatMB(res.Used))
+	}
+	lastSweep = res
+	return res, nil
+}
+
+// handleStorage shows disk usage of generated images and workspaces to
+// owners. "/storage sweep" runs the janitor right away.
+func handleStorage(m *telegram.NewMessage) error {
+	if !config.IsOwner(m.SenderID()) {
+		m.Reply("Only the bot owner can view storage.")
+		return nil
+	}
+
+	if strings.EqualFold(strings.TrimSpace(m.Args()), "sweep") {
+		res, err := sweepStorage(context.Background())
+		if err != nil {
+			logger.Printf("Storage sweep failed: %v", err)
+			m.Reply("Couldn't sweep storage. Try again later.")
+			return nil
+		}
+		m.Reply(fmt.Sprintf("🧹 Removed %d old files, %d least recently used files and %d stale scratch folders, freeing %s. %s in use.",
+			res.Expired, res.Evicted, res.Scratch, formatMB(res.Freed), formatMB(res.Used)))
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var sb strings.Builder
+	sb.WriteString("🗄 **Storage**\n\n")
+
+	var total int64
+	perChat := make(map[int64]int64)
+	for _, vol := range []struct {
+		name string
+		m    *workspace.Manager
+	}{
+		{"Generated images", generatedImages},
+		{"Workspaces", workspaces},
+	} {
+		files, err := vol.m.Files()
+		if err != nil {
+			logger.Printf("Failed to list %s: %v", vol.m.Root, err)
+			m.Reply("Couldn't read storage usage.")
+			return nil
+		}
+		var size int64
+		for _, f := range files {
+			size += f.Size
+			perChat[f.ChatID] += f.Size
+		}
+		total += size
+		sb.WriteString(fmt.Sprintf("%s: %s in %d files\n", vol.name, formatMB(size), len(files)))
+	}
+
+	budget := int64(config.StorageBudgetMB) << 20
+	sb.WriteString(fmt.Sprintf("Total: %s of %s\n", formatMB(total), formatMB(budget)))
+
+	pinned, err := db.Collection("generated_images").CountDocuments(ctx, bson.M{"pinned": true})
+	if err != nil {
+		logger.Printf("Failed to count pinned images: %v", err)
+	}
+	janitor := "off"
+	if config.StorageJanitor {
+		janitor = fmt.Sprintf("every %s", config.StorageSweepInterval)
+	}
+	maxAge := "off"
+	if config.StorageMaxAge > 0 {
+		maxAge = fmt.Sprintf("%d days", int(config.StorageMaxAge.Hours()/24))
+	}
+	sb.WriteString(fmt.Sprintf("Max age: %s · Pinned images: %d · Janitor: %s\n", maxAge, pinned, janitor))
+
+	chats := make([]int64, 0, len(perChat))
+	for id := range perChat {
+		if id != 0 {
+			chats = append(chats, id)
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool { return perChat[chats[i]] > perChat[chats[j]] })
+	if len(chats) > 0 {
+		sb.WriteString("\n**Largest chats**\n")
+		for i, id := range chats {
+			if i == 5 {
+				break
+			}
+			sb.WriteString(fmt.Sprintf("%d. `%d`: %s\n", i+1, id, formatMB(perChat[id])))
+		}
+	}
+
+	sweepMu.Lock()
+	res := lastSweep
+	sweepMu.Unlock()
+	if res != nil {
+		sb.WriteString(fmt.Sprintf("\nLast sweep: %s UTC, freed %s (%d old, %d least recently used)",
+			res.At.UTC().Format("2006-01-02 15:04"), formatMB(res.Freed), res.Expired, res.Evicted))
+	}
+	sb.WriteString("\n\nUse `/storage sweep` to run the janitor now.")
+
+	m.Reply(sb.String(), &telegram.SendOptions{ParseMode: "Markdown"})
+	return nil
+}
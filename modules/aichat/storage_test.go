@@ -0,0 +1,66 @@
+package aichat
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"zeno/workspace"
+)
+
+func TestPlanSweep(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	file := func(path string, size int64, age time.Duration) workspace.File {
+		return workspace.File{Path: path, Size: size, LastUsed: now.Add(-age)}
+	}
+	scratch := file("scratch", 50, 100*day)
+	scratch.Scratch = true
+
+	files := []workspace.File{
+		file("old", 10, 40*day),
+		file("pinned", 40, 90*day),
+		scratch,
+		file("a", 20, 5*day),
+		file("b", 20, 3*day),
+		file("c", 20, time.Hour),
+	}
+	pinned := map[string]bool{"pinned": true}
+
+	tests := []struct {
+		name    string
+		maxAge  time.Duration
+		budget  int64
+		expired []string
+		evicted []string
+		used    int64
+	}{
+		{"within budget", 30 * day, 1000, []string{"old"}, nil, 150},
+		{"max age off", 0, 1000, nil, nil, 160},
+		{"least recently used first", 30 * day, 120, []string{"old"}, []string{"a", "b"}, 110},
+		{"max age off, over budget", 0, 140, nil, []string{"old", "a"}, 130},
+		// Pinned and scratch files alone are over budget; everything else goes
+		{"budget below kept files", 30 * day, 50, []string{"old"}, []string{"a", "b", "c"}, 90},
+		{"pinned never expire", day / 2, 1000, []string{"old", "a", "b"}, nil, 110},
+	}
+	for _, tt := range tests {
+		plan := planSweep(files, pinned, now, tt.maxAge, tt.budget)
+		if got := paths(plan.Expired); !slices.Equal(got, tt.expired) {
+			t.Errorf("%s: expired %v, want %v", tt.name, got, tt.expired)
+		}
+		if got := paths(plan.Evicted); !slices.Equal(got, tt.evicted) {
+			t.Errorf("%s: evicted %v, want %v", tt.name, got, tt.evicted)
+		}
+		if plan.Used != tt.used {
+			t.Errorf("%s: used %d, want %d", tt.name, plan.Used, tt.used)
+		}
+	}
+}
+
+func paths(files []workspace.File) []string {
+	var out []string
+	for _, f := range files {
+		out = append(out, f.Path)
+	}
+	return out
+}
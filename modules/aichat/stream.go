@@ -0,0 +1,179 @@
+package aichat
+
+import (
+	"context"
+	"html"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"google.golang.org/genai"
+
+	"zeno/config"
+)
+
+// Telegram rejects edits longer than this.
+const maxMessageLen = 4096
+
+// streamEditor edits the placeholder as a response streams in. Edits are
+// at least an interval apart and need a minimum number of new characters,
+// and are paused entirely while Telegram reports a flood wait.
+type streamEditor struct {
+	mu           sync.Mutex
+	msg          *telegram.NewMessage
+	minChars     int
+	interval     time.Duration
+	lastText     string
+	lastEdit     time.Time
+	blockedUntil time.Time
+}
+
+func newStreamEditor(msg *telegram.NewMessage, group bool) *streamEditor {
+	interval := config.StreamEditInterval
+	if group && interval < config.StreamGroupEditInterval {
+		interval = config.StreamGroupEditInterval
+	}
+	return &streamEditor{
+		msg:      msg,
+		minChars: config.StreamEditChars,
+		interval: interval,
+		lastEdit: time.Now(),
+	}
+}
+
+// Update shows partial text when enough has changed since the last edit.
+// Partial text is sent without formatting since markup may be unbalanced.
+func (e *streamEditor) Update(text string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(e.blockedUntil) {
+		return
+	}
+	// The interval is a hard floor; Telegram floods on frequent edits however
+	// much text arrived
+	if now.Sub(e.lastEdit) < e.interval {
+		return
+	}
+	if len(text)-len(e.lastText) < e.minChars {
+		return
+	}
+	if runes := []rune(text); len(runes) > maxMessageLen-2 {
+		text = string(runes[:maxMessageLen-2]) + " …"
+	}
+	e.edit(text, &telegram.SendOptions{ParseMode: "HTML"}, true)
+}
+
+// Status replaces the placeholder with a short status line, such as the tool
+// being called. It is skipped while a flood wait is active.
+func (e *streamEditor) Status(text string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if time.Now().Before(e.blockedUntil) {
+		return
+	}
+	e.edit(text, nil, false)
+}
+
+// Final waits out any flood wait and then makes the last edit.
+func (e *streamEditor) Final(text string, opts *telegram.SendOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if wait := time.Until(e.blockedUntil); wait > 0 {
+			time.Sleep(wait)
+		}
+		// The formatted text must be sent even if the plain text matches
+		e.lastText = ""
+		err := e.edit(text, opts, false)
+		if telegram.GetFloodWait(err) == 0 {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *streamEditor) edit(text string, opts *telegram.SendOptions, escape bool) error {
+	if text == e.lastText {
+		return nil
+	}
+
+	body := text
+	if escape {
+		body = html.EscapeString(text)
+	}
+	var err error
+	if opts != nil {
+		_, err = e.msg.Edit(body, opts)
+	} else {
+		_, err = e.msg.Edit(body)
+	}
+	e.lastEdit = time.Now()
+
+	if wait := telegram.GetFloodWait(err); wait > 0 {
+		logger.Printf("Flood wait of %ds while editing message %d", wait, e.msg.ID)
+		e.blockedUntil = time.Now().Add(time.Duration(wait) * time.Second)
+		return err
+	}
+	if err != nil && !telegram.MatchError(err, "MESSAGE_NOT_MODIFIED") {
+		logger.Printf("Failed to edit message %d: %v", e.msg.ID, err)
+		return err
+	}
+	e.lastText = text
+	return nil
+}
+
+// streamTurn runs one streamed model call and assembles the chunks into a
+// single model content. Text is forwarded to the editor as it arrives.
+func streamTurn(ctx context.Context, contents []*genai.Content, cfg *genai.GenerateContentConfig, editor *streamEditor) (*genai.Candidate, *genai.GenerateContentResponseUsageMetadata, error) {
+	candidate := &genai.Candidate{Content: &genai.Content{Role: genai.RoleModel}}
+	var usage *genai.GenerateContentResponseUsageMetadata
+	var text strings.Builder
+	var textPart *genai.Part
+
+	for resp, err := range provider.Stream(ctx, config.DefaultModel, contents, cfg) {
+		if err != nil {
+			return nil, usage, err
+		}
+		// Usage is cumulative; the last chunk carries the totals
+		if resp.UsageMetadata != nil {
+			usage = resp.UsageMetadata
+		}
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			continue
+		}
+
+		chunk := resp.Candidates[0]
+		if chunk.GroundingMetadata != nil {
+			candidate.GroundingMetadata = chunk.GroundingMetadata
+		}
+		if chunk.FinishReason != "" {
+			candidate.FinishReason = chunk.FinishReason
+		}
+
+		for _, part := range chunk.Content.Parts {
+			switch {
+			case part.Thought:
+				continue
+			case part.FunctionCall != nil:
+				candidate.Content.Parts = append(candidate.Content.Parts, part)
+				textPart = nil
+			case part.Text != "":
+				// Consecutive text chunks become one part
+				if textPart == nil {
+					textPart = &genai.Part{ThoughtSignature: part.ThoughtSignature}
+					candidate.Content.Parts = append(candidate.Content.Parts, textPart)
+				}
+				textPart.Text += part.Text
+				text.WriteString(part.Text)
+				editor.Update(text.String())
+			}
+		}
+	}
+
+	return candidate, usage, nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"time"
@@ -50,16 +49,16 @@ func ensureTelegraphToken() {
 	err := db.Collection("system_settings").FindOne(ctx, bson.M{"_id": "telegraph_token"}).Decode(&account)
 	if err == nil && account.AccessToken != "" {
 		config.TelegraphAccessToken = account.AccessToken
-		log.Println("[AiChat] Loaded Telegraph token from DB")
+		logger.Println("Loaded Telegraph token from DB")
 		return
 	}
 
-	log.Println("[AiChat] No Telegraph token found in env or DB, generating new account...")
+	logger.Println("No Telegraph token found in env or DB, generating new account...")
 
 	// Generate new token
 	newAccount, err := createTelegraphAccount()
 	if err != nil {
-		log.Printf("[AiChat] Failed to create Telegraph account: %v", err)
+		logger.Printf("Failed to create Telegraph account: %v", err)
 		return
 	}
 
@@ -71,9 +70,9 @@ func ensureTelegraphToken() {
 		options.Update().SetUpsert(true),
 	)
 	if err != nil {
-		log.Printf("[AiChat] Failed to store Telegraph token in DB: %v", err)
+		logger.Printf("Failed to store Telegraph token in DB: %v", err)
 	} else {
-		log.Println("[AiChat] Stored new Telegraph token in DB")
+		logger.Println("Stored new Telegraph token in DB")
 	}
 
 	config.TelegraphAccessToken = newAccount.AccessToken
@@ -103,7 +102,7 @@ func createTelegraphAccount() (TelegraphAccount, error) {
 	return result.Result, nil
 }
 
-func uploadToTelegraph(title, content string) (string, error) {
+func uploadToTelegraph(title, author, content string) (string, error) {
 	if config.TelegraphAccessToken == "" {
 		// Fallback attempt to ensure creation if missing at runtime
 		ensureTelegraphToken()
@@ -112,12 +111,7 @@ func uploadToTelegraph(title, content string) (string, error) {
 		}
 	}
 
-	nodes := []map[string]interface{}{
-		{
-			"tag":      "p",
-			"children": []string{content},
-		},
-	}
+	nodes := markdownToNodes(content)
 
 	nodesBytes, err := json.Marshal(nodes)
 	if err != nil {
@@ -128,7 +122,7 @@ func uploadToTelegraph(title, content string) (string, error) {
 	data := url.Values{}
 	data.Set("access_token", config.TelegraphAccessToken)
 	data.Set("title", title)
-	data.Set("author_name", "Nitya")
+	data.Set("author_name", author)
 	data.Set("content", string(nodesBytes))
 	data.Set("return_content", "true")
 
@@ -0,0 +1,729 @@
+package aichat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"google.golang.org/genai"
+
+	"zeno/config"
+	"zeno/llm"
+	"zeno/sandbox"
+	"zeno/tools"
+)
+
+var builtinTools = []tools.Tool{
+	createImageTool{},
+	editImageTool{},
+	sendFileTool{},
+	runCodeTool{},
+	webSearchTool{},
+	fetchURLTool{},
+}
+
+func registerTools() error {
+	for _, t := range builtinTools {
+		if err := tools.Register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unregisterTools() {
+	for _, t := range builtinTools {
+		tools.Default.Unregister(t.Name())
+	}
+}
+
+type createImageTool struct{}
+
+var createImageSchema = tools.MustSchema(`{
+	"type": "object",
+	"properties": {
+		"prompt": {
+			"type": "string",
+			"description": "Detailed prompt describing the image to generate"
+		},
+		"aspect_ratio": {
+			"type": "string",
+			"description": "Aspect ratio. Values: 1:1, 9:16, 16:9, 3:4, 4:3, 3:2, 2:3, 5:4, 4:5, 21:9. Empty for auto."
+		},
+		"high_quality": {
+			"type": "boolean",
+			"description": "Use HIGH mode (Gemini 3 Pro, 2K). COSTS MORE - only use when the creator explicitly requests."
+		},
+		"count": {
+			"type": "integer",
+			"description": "Number of variations to generate, 1-4. Defaults to 1."
+		}
+	},
+	"required": ["prompt"]
+}`)
+
+func (createImageTool) Name() string { return "create_image" }
+
+func (createImageTool) Description() string {
+	return "Generate one or more images from a text prompt. Returns the file paths of the generated images."
+}
+
+func (createImageTool) Schema() *genai.Schema { return createImageSchema }
+
+func (createImageTool) Permission() tools.Permission { return tools.PermissionUser }
+
+func (createImageTool) RateClass() string { return config.RateImage }
+
+func (createImageTool) Prompt() string {
+	return `- **create_image**: Generate images from text prompts. Params: prompt (required), aspect_ratio (optional: 1:1, 9:16, 16:9, 3:4, 4:3, 3:2, 2:3, 5:4, 4:5, 21:9), high_quality (optional: boolean), count (optional: 1-4 variations)
+  - ⚠️ WARNING: high_quality=true uses Gemini 3 Pro which COSTS MORE. Only use high_quality=true when @{{.Creator}} explicitly asks for it.
+  - Each variation counts as one image. Only use count > 1 when the user asks for options or variations.
+  - Generated images are saved to /generated/
+  - Workflow: create_image → returns file_paths → send_file with those file_paths (several are sent as one album)`
+}
+
+func (createImageTool) Execute(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	return executeCreateImage(ctx, cc, args)
+}
+
+type sendFileTool struct{}
+
+var sendFileSchema = tools.MustSchema(`{
+	"type": "object",
+	"properties": {
+		"file_path": {
+			"type": "string",
+			"description": "Path to the file to send"
+		},
+		"file_paths": {
+			"type": "array",
+			"items": {"type": "string"},
+			"description": "Paths of up to 10 files to send together as one album"
+		},
+		"captions": {
+			"type": "array",
+			"items": {"type": "string"},
+			"description": "Caption for each file, in the same order as the paths"
+		},
+		"send_as": {
+			"type": "string",
+			"description": "document keeps full quality (default), photo shows images inline but compressed, both sends each way",
+			"enum": ["document", "photo", "both"]
+		}
+	}
+}`)
+
+func (sendFileTool) Name() string { return "send_file" }
+
+func (sendFileTool) Description() string {
+	return "Send one or more files to the user in the chat. Use after generating an image."
+}
+
+func (sendFileTool) Schema() *genai.Schema { return sendFileSchema }
+
+func (sendFileTool) Permission() tools.Permission { return tools.PermissionUser }
+
+func (sendFileTool) Prompt() string {
+	return `- **send_file**: Send a file to the user. Params: file_path or file_paths (up to 10, sent as one album), captions (optional, one per file), send_as (optional: document, photo, both). Can access this chat's /generated/ and /workspace/ only
+  - send_as=document (default) keeps full quality; photo previews inline but Telegram compresses it; both sends the photos and then the originals
+  - For variations, caption each image so the user can tell them apart (e.g. "1: watercolor", "2: pencil")`
+}
+
+func (sendFileTool) Execute(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	return executeSendFile(cc, args)
+}
+
+type runCodeTool struct{}
+
+var runCodeSchema = tools.MustSchema(fmt.Sprintf(`{
+	"type": "object",
+	"properties": {
+		"language": {
+			"type": "string",
+			"description": "Programming language",
+			"enum": %s
+		},
+		"code": {
+			"type": "string",
+			"description": "The code to execute. For bash, can be a command like 'excol /generated/img.png'"
+		}
+	},
+	"required": ["language", "code"]
+}`, languageEnum()))
+
+func (runCodeTool) Name() string { return "run_code" }
+
+func (runCodeTool) Description() string {
+	return "Execute code in a sandboxed container. Has access to this chat's /generated (images) and /workspace. Languages: " + languageList() + "."
+}
+
+func (runCodeTool) Schema() *genai.Schema { return runCodeSchema }
+
+func (runCodeTool) Permission() tools.Permission { return tools.PermissionUser }
+
+func (runCodeTool) RateClass() string { return config.RateCode }
+
+func (runCodeTool) Prompt() string {
+	return `- **run_code**: Execute code in a sandboxed container. Params: language, code
+  - Languages: ` + languageList() + `
+  - Compiled languages report a failed build with phase "compile"
+  - /workspace is this chat's own folder and keeps files between requests; $SCRATCH is a folder deleted after this request
+  - Files users send are saved in /workspace/uploads/; their paths appear in the conversation as "saved to ..."
+  - Results carry exit_code, duration_ms, stdout/stderr (cut off when *_truncated is true), timed_out and limit_exceeded, the limits the run hit: memory, output, processes, disk or workspace_quota
+  - New or changed files come back as "artifacts" (path, size, mime); send them with send_file unless marked "sent"
+  - New files that push the workspace past its quota are deleted and listed in "removed_over_quota"
+  - /generated holds this chat's images, read-only
+  - Python packages: pillow, numpy, colorthief, opencv
+  - Commands: excol (color extraction), imgresize
+  - Workflow: run_code to create in /workspace/ → send_file with the artifact's path`
+}
+
+func (runCodeTool) Execute(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	return executeRunCode(ctx, cc, args)
+}
+
+// runLimits picks the limits for a run: the defaults, overridden by the
+// language's own and configured settings. The user role's limits can only
+// tighten those, so a language that needs more doesn't hand it to everyone;
+// admin and owner limits replace them.
+func runLimits(lang *language, perm tools.Permission) config.RunLimits {
+	limits := config.SandboxLimits.
+		Merge(lang.Limits).
+		Merge(config.LanguageRunLimits[lang.Name])
+	role := config.RoleRunLimits[perm.String()]
+	if perm == tools.PermissionUser {
+		return limits.Cap(role)
+	}
+	return limits.Merge(role)
+}
+
+// runResponse reports a finished run to the model. Output beyond the run's
+// limit was already dropped by the sandbox; the byte counts tell the model
+// how much there was.
+func runResponse(result *sandbox.Result) map[string]any {
+	stdoutTruncated := result.StdoutBytes > int64(len(result.Stdout))
+	stderrTruncated := result.StderrBytes > int64(len(result.Stderr))
+
+	exceeded := []string{}
+	if result.OOMKilled {
+		exceeded = append(exceeded, "memory")
+	}
+	if stdoutTruncated || stderrTruncated {
+		exceeded = append(exceeded, "output")
+	}
+	// Neither sandbox reports these directly; the errors the code got for
+	// them end up in stderr
+	if bytes.Contains(result.Stderr, []byte("Resource temporarily unavailable")) {
+		exceeded = append(exceeded, "processes")
+	}
+	if bytes.Contains(result.Stderr, []byte("No space left on device")) {
+		exceeded = append(exceeded, "disk")
+	}
+
+	return map[string]any{
+		"success":          result.ExitCode == 0 && !result.TimedOut && !result.OOMKilled,
+		"exit_code":        result.ExitCode,
+		"duration_ms":      result.Duration.Milliseconds(),
+		"stdout":           strings.ToValidUTF8(string(result.Stdout), ""),
+		"stdout_bytes":     result.StdoutBytes,
+		"stdout_truncated": stdoutTruncated,
+		"stderr":           strings.ToValidUTF8(string(result.Stderr), ""),
+		"stderr_bytes":     result.StderrBytes,
+		"stderr_truncated": stderrTruncated,
+		"timed_out":        result.TimedOut,
+		"limit_exceeded":   exceeded,
+	}
+}
+
+// How send_file delivers files. Documents keep the original bytes; photos
+// preview inline but Telegram recompresses them.
+const (
+	sendAsDocument = "document"
+	sendAsPhoto    = "photo"
+	sendAsBoth     = "both"
+)
+
+// maxAlbumSize is the most items Telegram allows in one album.
+const maxAlbumSize = 10
+
+// sendChatFile uploads a file of the caller's chat as a document, after the
+// checks in openSendable.
+func sendChatFile(cc *tools.CallContext, filePath, caption string) error {
+	return sendChatFiles(cc, []string{filePath}, []string{caption}, sendAsDocument)
+}
+
+// sendChatFiles uploads files of the caller's chat, after the checks in
+// openSendable. Several files go out as one album, captioned by position.
+// With sendAsBoth the photo album is followed by the same files as
+// documents; each file is uploaded once either way.
+func sendChatFiles(cc *tools.CallContext, filePaths, captions []string, sendAs string) error {
+	uploads := make([]telegram.InputFile, len(filePaths))
+	names := make([]string, len(filePaths))
+	for i, p := range filePaths {
+		f, mimeType, err := openSendable(cc, p)
+		if err != nil {
+			if len(filePaths) > 1 {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			return err
+		}
+		if sendAs != sendAsDocument && !strings.HasPrefix(mimeType, "image/") {
+			f.Close()
+			return fmt.Errorf("%s is %s; only images can be sent as photos", p, mimeType)
+		}
+
+		logger.Printf("Sending file %s (%s) to chat %d", p, mimeType, cc.ChatID)
+
+		names[i] = filepath.Base(f.Name())
+		uploads[i], err = cc.Client.UploadFile(f, &telegram.UploadOptions{FileName: names[i]})
+		f.Close()
+		if err != nil {
+			logger.Printf("Failed to upload file: %v", err)
+			return err
+		}
+	}
+
+	var asDocument []bool
+	switch sendAs {
+	case sendAsPhoto:
+		asDocument = []bool{false}
+	case sendAsBoth:
+		asDocument = []bool{false, true}
+	default:
+		asDocument = []bool{true}
+	}
+	var sent []*telegram.NewMessage
+	for _, doc := range asDocument {
+		msgs, err := sendUploads(cc, uploads, names, captions, doc)
+		if err != nil {
+			logger.Printf("Failed to send file: %v", err)
+			return err
+		}
+		sent = msgs
+	}
+	rememberFileIDs(cc.ChatID, filePaths, sent)
+	return nil
+}
+
+// sendUploads sends uploaded files as a single message or an album.
+func sendUploads(cc *tools.CallContext, uploads []telegram.InputFile, names, captions []string, asDocument bool) ([]*telegram.NewMessage, error) {
+	media := make([]telegram.InputMedia, len(uploads))
+	for i, u := range uploads {
+		m, err := cc.Client.GetSendableMedia(u, &telegram.MediaMetadata{
+			FileName:      names[i],
+			ForceDocument: asDocument,
+			// Album items must already be on Telegram's side
+			Inline: len(uploads) > 1,
+		})
+		if err != nil {
+			return nil, err
+		}
+		media[i] = m
+	}
+
+	if len(media) == 1 {
+		caption := ""
+		if len(captions) > 0 {
+			caption = captions[0]
+		}
+		msg, err := cc.Client.SendMedia(cc.ChatID, media[0], &telegram.MediaOptions{
+			ReplyTo: &telegram.InputReplyToMessage{
+				ReplyToMsgID: cc.ReplyToMsgID,
+			},
+			Caption: caption,
+		})
+		return []*telegram.NewMessage{msg}, err
+	}
+
+	return cc.Client.SendAlbum(cc.ChatID, media, &telegram.MediaOptions{
+		ReplyID: cc.ReplyToMsgID,
+		Caption: captions,
+		// The default waits 5s after every batch of 10
+		SleepThresholdMs: 1,
+	})
+}
+
+// stringArgs returns the non-empty strings of an array argument.
+func stringArgs(args map[string]any, key string) []string {
+	var out []string
+	if list, ok := args[key].([]any); ok {
+		for _, item := range list {
+			if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+				out = append(out, strings.TrimSpace(s))
+			}
+		}
+	}
+	return out
+}
+
+// Valid aspect ratios for image generation
+var validAspectRatios = map[string]bool{
+	"1:1": true, "9:16": true, "16:9": true, "3:4": true, "4:3": true,
+	"3:2": true, "2:3": true, "5:4": true, "4:5": true, "21:9": true,
+}
+
+func executeCreateImage(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	prompt, _ := args["prompt"].(string)
+	aspectRatio, _ := args["aspect_ratio"].(string)
+	highQuality, _ := args["high_quality"].(bool)
+	count := 1
+	if n, ok := args["count"].(float64); ok {
+		count = min(max(int(n), 1), maxImageVariations)
+	}
+
+	if prompt == "" {
+		return map[string]any{
+			"success": false,
+			"error":   "prompt is required",
+		}
+	}
+
+	remaining, reason := quotaRemaining(cc.UserID, cc.ChatID, true)
+	if reason != "" {
+		return tools.Error(reason)
+	}
+
+	// Each variation is an image against the quota and the image rate limit.
+	// throttleTool already took the first token.
+	requested := count
+	if remaining >= 0 && int64(count) > remaining {
+		count = int(remaining)
+	}
+	if count > 1 {
+		count = 1 + takeRate(cc.UserID, cc.ChatID, config.RateImage, count-1)
+	}
+
+	// Validate aspect ratio
+	if aspectRatio != "" && !validAspectRatios[aspectRatio] {
+		aspectRatio = "" // Invalid, use auto
+	}
+
+	req := &llm.ImageRequest{
+		Prompt:      prompt,
+		AspectRatio: aspectRatio,
+	}
+	result := generateImage(ctx, cc, req, highQuality, count)
+	if count < requested {
+		logger.Printf("Reduced create_image count from %d to %d for user %d in chat %d", requested, count, cc.UserID, cc.ChatID)
+		result["note"] = fmt.Sprintf("Only %d of the %d requested variations were made because of the image quota or rate limit. Tell the user.", count, requested)
+	}
+	return result
+}
+
+// maxImageVariations caps create_image's count.
+const maxImageVariations = 4
+
+// generateImage runs the image model count times in parallel and saves the
+// first image of each run to the chat's generated folder. Runs that fail
+// are reported alongside the ones that worked.
+func generateImage(ctx context.Context, cc *tools.CallContext, req *llm.ImageRequest, highQuality bool, count int) map[string]any {
+	// Choose model based on quality
+	model := config.ImageModel
+	if highQuality {
+		model = config.HighImageModel
+	}
+
+	logger.Printf("Generating image with model %s (high=%v, aspect=%s, inputs=%d): %s", model, highQuality, req.AspectRatio, len(req.Inputs), req.Prompt)
+
+	ctx, cancel := context.WithTimeout(ctx, 90*time.Second)
+	defer cancel()
+
+	if highQuality {
+		req.Size = "2K"
+		// Edits keep the source's shape unless asked otherwise
+		if req.AspectRatio == "" && len(req.Inputs) == 0 {
+			req.AspectRatio = "9:16" //IDK, model loves to provide 16:9, but i like 9:16. subjective.
+		}
+	}
+
+	results := make([]*llm.ImageResult, count)
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for i := range count {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = provider.GenerateImage(ctx, model, req)
+		}()
+	}
+	wg.Wait()
+
+	var (
+		filePaths []string
+		imageIDs  []string
+		failures  []string
+		size      int
+	)
+	for i, result := range results {
+		if errs[i] != nil {
+			logger.Printf("Image generation failed: %v", errs[i])
+			failures = append(failures, errs[i].Error())
+			continue
+		}
+		recordUsage(cc.UserID, cc.ChatID, model, result.Usage, 1)
+
+		img := result.Images[0]
+		filePath, err := saveGeneratedImage(cc, img)
+		if err != nil {
+			logger.Printf("Failed to save image: %v", err)
+			failures = append(failures, "Failed to save image")
+			continue
+		}
+		filePaths = append(filePaths, filePath)
+		size += len(img.Data)
+		if id := recordGeneratedImage(cc, req, model, highQuality, filePath); id != "" {
+			imageIDs = append(imageIDs, id)
+		}
+	}
+
+	if len(filePaths) == 0 {
+		return map[string]any{
+			"success": false,
+			"error":   failures[0],
+		}
+	}
+
+	response := map[string]any{
+		"success":   true,
+		"file_path": filePaths[0],
+		"prompt":    req.Prompt,
+		"size":      size,
+	}
+	if len(imageIDs) > 0 {
+		response["image_id"] = imageIDs[0]
+	}
+	if count > 1 {
+		response["file_paths"] = filePaths
+		response["image_ids"] = imageIDs
+	}
+	if len(failures) > 0 {
+		response["failed"] = len(failures)
+		response["errors"] = failures
+	}
+	return response
+}
+
+// saveGeneratedImage writes an image to the chat's generated folder and
+// returns its path as the model sees it.
+func saveGeneratedImage(cc *tools.CallContext, img llm.Image) (string, error) {
+	ext := ".png"
+	if strings.Contains(img.MIMEType, "jpeg") {
+		ext = ".jpg"
+	} else if strings.Contains(img.MIMEType, "webp") {
+		ext = ".webp"
+	}
+
+	gen, err := generatedImages.Open(cc.ChatID)
+	if err != nil {
+		return "", err
+	}
+	filename := fmt.Sprintf("img_%d%s", time.Now().UnixNano(), ext)
+	filePath := filepath.Join(gen.Dir, filename)
+
+	if err := os.WriteFile(filePath, img.Data, 0644); err != nil {
+		return "", err
+	}
+
+	logger.Printf("Image saved to %s (%d bytes)", filePath, len(img.Data))
+	return path.Join(gen.Mount, filename), nil
+}
+
+func executeSendFile(cc *tools.CallContext, args map[string]any) map[string]any {
+	filePaths := stringArgs(args, "file_paths")
+	if p, _ := args["file_path"].(string); strings.TrimSpace(p) != "" {
+		filePaths = append([]string{strings.TrimSpace(p)}, filePaths...)
+	}
+
+	if len(filePaths) == 0 {
+		return map[string]any{
+			"success": false,
+			"error":   "file_path or file_paths is required",
+		}
+	}
+	if len(filePaths) > maxAlbumSize {
+		return tools.Error(fmt.Sprintf("At most %d files can be sent at once", maxAlbumSize))
+	}
+
+	sendAs, _ := args["send_as"].(string)
+	switch sendAs {
+	case "":
+		sendAs = sendAsDocument
+	case sendAsDocument, sendAsPhoto, sendAsBoth:
+	default:
+		return tools.Error("send_as must be document, photo or both")
+	}
+
+	// Captions are optional; images from create_image get a default one
+	captions, _ := args["captions"].([]any)
+	texts := make([]string, len(filePaths))
+	for i, p := range filePaths {
+		if i < len(captions) {
+			texts[i], _ = captions[i].(string)
+		}
+		if texts[i] == "" && strings.HasPrefix(p, generatedMount+"/") {
+			texts[i] = "🎨 Generated image"
+			if len(filePaths) > 1 {
+				texts[i] = fmt.Sprintf("🎨 %d/%d", i+1, len(filePaths))
+			}
+		}
+	}
+
+	if err := sendChatFiles(cc, filePaths, texts, sendAs); err != nil {
+		return tools.Error(err.Error())
+	}
+
+	message := "File sent successfully"
+	if len(filePaths) > 1 {
+		message = fmt.Sprintf("%d files sent as an album", len(filePaths))
+	}
+	return map[string]any{
+		"success": true,
+		"message": message,
+	}
+}
+
+func executeRunCode(ctx context.Context, cc *tools.CallContext, args map[string]any) map[string]any {
+	language, _ := args["language"].(string)
+	code, _ := args["code"].(string)
+
+	if language == "" || code == "" {
+		return map[string]any{
+			"success": false,
+			"error":   "language and code are required",
+		}
+	}
+
+	lang := lookupLanguage(language)
+	if lang == nil {
+		return tools.Error("Invalid language. Use one of: " + strings.Join(languageNames(), ", "))
+	}
+
+	ws, err := chatWorkspace(cc)
+	if err != nil {
+		logger.Printf("Failed to open workspace of chat %d: %v", cc.ChatID, err)
+		return tools.Error("Couldn't open the chat's workspace")
+	}
+	if full, used, err := ws.OverQuota(); err == nil && full {
+		return quotaError(ws, used)
+	}
+	gen, err := generatedImages.Open(cc.ChatID)
+	if err != nil {
+		logger.Printf("Failed to open generated images folder of chat %d: %v", cc.ChatID, err)
+		return tools.Error("Couldn't open the chat's images")
+	}
+
+	before, err := ws.Snapshot()
+	if err != nil {
+		logger.Printf("Failed to scan workspace of chat %d: %v", cc.ChatID, err)
+	}
+
+	limits := runLimits(lang, cc.Permission)
+
+	dir, cleanup, err := writeSource(cc, ws, lang, code)
+	if err != nil {
+		logger.Printf("Failed to save code in chat %d: %v", cc.ChatID, err)
+		return tools.Error("Couldn't save the code to the workspace")
+	}
+
+	spec := sandbox.Spec{
+		Image: lang.image(),
+		Env: append([]string{
+			"HOME=/tmp",
+			"SCRATCH=" + cc.Scratch,
+			// Relative to the working directory, so the local driver finds them too
+			"SRC=./" + path.Join(strings.TrimPrefix(dir, ws.Mount+"/"), "main"+lang.Extension),
+			"BIN=./" + path.Join(strings.TrimPrefix(dir, ws.Mount+"/"), "main"),
+		}, lang.Env...),
+		WorkDir: ws.Mount,
+		Mounts: []sandbox.Mount{
+			chatMount(config.SandboxWorkspaceVolume, ws, false),
+			chatMount(config.SandboxGeneratedVolume, gen, true),
+		},
+		Limits: sandbox.Limits{
+			MemoryBytes: int64(limits.MemoryMB) << 20,
+			CPUs:        limits.CPUs,
+			Pids:        int64(limits.Pids),
+			Network:     config.SandboxNetwork,
+			OutputBytes: int64(limits.OutputKB) << 10,
+		},
+		Timeout: limits.Timeout,
+	}
+
+	logger.Printf("Running code (%s) with %s sandbox in chat %d: %s", lang.Name, codeSandbox.Name(), cc.ChatID, truncateString(code, 100))
+
+	phase := "run"
+	var result *sandbox.Result
+	if lang.Compile != "" {
+		phase = "compile"
+		spec.Cmd = []string{"bash", "-c", lang.Compile}
+		result, err = codeSandbox.Run(ctx, spec)
+	}
+	if err == nil && (result == nil || result.ExitCode == 0 && !result.TimedOut && !result.OOMKilled) {
+		phase = "run"
+		spec.Cmd = []string{"bash", "-c", lang.Run}
+		result, err = codeSandbox.Run(ctx, spec)
+	}
+	// The source and binary are not artifacts
+	cleanup()
+	if err != nil {
+		logger.Printf("Sandbox error: %v", err)
+		return map[string]any{
+			"success": false,
+			"error":   fmt.Sprintf("Couldn't start the sandbox: %v", err),
+		}
+	}
+
+	response := runResponse(result)
+	response["phase"] = phase
+	switch {
+	case result.TimedOut:
+		response["error"] = fmt.Sprintf("Execution timed out (%s limit)", limits.Timeout)
+	case result.OOMKilled:
+		response["error"] = fmt.Sprintf("Execution ran out of memory (%d MB limit)", limits.MemoryMB)
+	case phase == "compile":
+		response["error"] = "Compilation failed"
+	case result.ExitCode != 0:
+		logger.Printf("Code execution exited with %d, stderr: %s", result.ExitCode, truncateString(string(result.Stderr), 500))
+		response["error"] = fmt.Sprintf("Execution failed with exit code %d", result.ExitCode)
+	default:
+		logger.Printf("Code execution successful in %s, output length: %d", result.Duration.Round(time.Millisecond), result.StdoutBytes)
+	}
+
+	// The quota is only checked before a run, so one run can write past it;
+	// whatever it created beyond the quota is deleted before anything is sent
+	if before != nil {
+		removed, err := ws.TrimNew(before)
+		if err != nil {
+			logger.Printf("Failed to trim workspace of chat %d: %v", cc.ChatID, err)
+		}
+		if len(removed) > 0 {
+			logger.Printf("Removed %d files over the workspace quota in chat %d", len(removed), cc.ChatID)
+			response["removed_over_quota"] = removed
+			response["limit_exceeded"] = append(response["limit_exceeded"].([]string), "workspace_quota")
+		}
+	}
+
+	// Failed runs can still leave useful files behind
+	if before != nil {
+		if artifacts, more := collectArtifacts(cc, ws, before); len(artifacts) > 0 {
+			response["artifacts"] = artifacts
+			if more > 0 {
+				response["more_artifacts"] = more
+			}
+		}
+	}
+
+	recordWorkspace(ws, cc.UserID)
+	if full, used, err := ws.OverQuota(); err == nil && full {
+		response["warning"] = fmt.Sprintf("The chat's workspace is now over its quota (%s of %s); further runs are refused until an admin runs /workspace clear",
+			formatMB(used), formatMB(ws.QuotaBytes))
+	}
+	return response
+}
@@ -0,0 +1,76 @@
+package aichat
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"zeno/config"
+	"zeno/sandbox"
+	"zeno/tools"
+)
+
+func TestRunResponseLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		result sandbox.Result
+		want   []string
+	}{
+		{"clean run", sandbox.Result{Stdout: []byte("ok"), StdoutBytes: 2}, []string{}},
+		{"out of memory", sandbox.Result{ExitCode: 137, OOMKilled: true}, []string{"memory"}},
+		{"stdout cut off", sandbox.Result{Stdout: []byte("abc"), StdoutBytes: 100}, []string{"output"}},
+		{"stderr cut off", sandbox.Result{Stderr: []byte("abc"), StderrBytes: 100}, []string{"output"}},
+		{"fork failed", sandbox.Result{ExitCode: 1, Stderr: []byte("bash: fork: Resource temporarily unavailable"), StderrBytes: 44}, []string{"processes"}},
+		{"disk full", sandbox.Result{ExitCode: 1, Stderr: []byte("OSError: [Errno 28] No space left on device"), StderrBytes: 43}, []string{"disk"}},
+	}
+	for _, tt := range tests {
+		resp := runResponse(&tt.result)
+		if got := resp["limit_exceeded"].([]string); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: limit_exceeded = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if resp := runResponse(&sandbox.Result{OOMKilled: true}); resp["success"] != false {
+		t.Error("a run killed for memory reported success")
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	defaults, languageLimits, roleLimits := config.SandboxLimits, config.LanguageRunLimits, config.RoleRunLimits
+	t.Cleanup(func() {
+		config.SandboxLimits, config.LanguageRunLimits, config.RoleRunLimits = defaults, languageLimits, roleLimits
+	})
+
+	config.SandboxLimits = config.RunLimits{Timeout: 30 * time.Second, MemoryMB: 512, CPUs: 1, Pids: 128, OutputKB: 16}
+	config.LanguageRunLimits = map[string]config.RunLimits{"java": {MemoryMB: 1024}}
+	config.RoleRunLimits = map[string]config.RunLimits{
+		"user":  {Timeout: 60 * time.Second, MemoryMB: 256, Pids: 64},
+		"owner": {Timeout: 120 * time.Second, MemoryMB: 2048},
+	}
+	java := &language{Name: "java", Limits: config.RunLimits{Timeout: 45 * time.Second, MemoryMB: 768}}
+	python := &language{Name: "python"}
+
+	tests := []struct {
+		name string
+		lang *language
+		perm tools.Permission
+		want config.RunLimits
+	}{
+		// The language's defaults, then its configured limits
+		{"admin without role limits", java, tools.PermissionAdmin,
+			config.RunLimits{Timeout: 45 * time.Second, MemoryMB: 1024, CPUs: 1, Pids: 128, OutputKB: 16}},
+		// User limits only tighten: the longer timeout is ignored
+		{"user", java, tools.PermissionUser,
+			config.RunLimits{Timeout: 45 * time.Second, MemoryMB: 256, CPUs: 1, Pids: 64, OutputKB: 16}},
+		{"user, plain language", python, tools.PermissionUser,
+			config.RunLimits{Timeout: 30 * time.Second, MemoryMB: 256, CPUs: 1, Pids: 64, OutputKB: 16}},
+		// Owner limits replace the language's, raising them too
+		{"owner", java, tools.PermissionOwner,
+			config.RunLimits{Timeout: 120 * time.Second, MemoryMB: 2048, CPUs: 1, Pids: 128, OutputKB: 16}},
+	}
+	for _, tt := range tests {
+		if got := runLimits(tt.lang, tt.perm); got != tt.want {
+			t.Errorf("%s: runLimits = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package aichat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zeno/config"
+	"zeno/db"
+	"zeno/llm"
+	"zeno/models"
+	"zeno/sandbox"
+	"zeno/tools"
+	"zeno/workspace"
+)
+
+// maxTranscriptMessage stays under Telegram's 4096 character limit.
+const maxTranscriptMessage = 4000
+
+// audioMeta describes a voice note or audio file as Telegram reports it.
+type audioMeta struct {
+	duration time.Duration
+	mimeType string
+}
+
+// messageAudio returns the audio details of msg, or nil when it carries
+// no voice note or audio file.
+func messageAudio(msg *telegram.NewMessage) *audioMeta {
+	if msg.Message == nil {
+		return nil
+	}
+	media, ok := msg.Message.Media.(*telegram.MessageMediaDocument)
+	if !ok {
+		return nil
+	}
+	doc, ok := media.Document.(*telegram.DocumentObj)
+	if !ok {
+		return nil
+	}
+	for _, attr := range doc.Attributes {
+		if a, ok := attr.(*telegram.DocumentAttributeAudio); ok {
+			return &audioMeta{duration: time.Duration(a.Duration) * time.Second, mimeType: doc.MimeType}
+		}
+	}
+	if strings.HasPrefix(doc.MimeType, "audio/") {
+		return &audioMeta{mimeType: doc.MimeType}
+	}
+	return nil
+}
+
+// voiceLine is how a transcript appears in the model's context.
+func voiceLine(sender, transcript string) string {
+	return fmt.Sprintf("[voice from %s]: %s\n", sender, strings.ReplaceAll(transcript, "\n", "\\n"))
+}
+
+// transcribeForContext transcribes a voice note or audio file for the
+// model's context. ok is false when msg has no audio or transcription
+// failed, in which case the caller falls back to attaching the file.
+func transcribeForContext(cc *tools.CallContext, msg *telegram.NewMessage) (string, bool) {
+	meta := messageAudio(msg)
+	if meta == nil {
+		return "", false
+	}
+	text, err := transcribeMessage(context.Background(), cc, msg, meta, config.TranscribeLanguage)
+	if err != nil {
+		logger.Printf("Failed to transcribe message %d in chat %d: %v", msg.ID, cc.ChatID, err)
+		return "", false
+	}
+	return text, true
+}
+
+// transcribeMessage turns msg's audio into text. Transcripts are kept with
+// the message in the history, so each message is only transcribed once per
+// language hint. Audio longer than TranscribeChunkSeconds, or too large to
+// send inline, is split with ffmpeg in the sandbox first.
+func transcribeMessage(ctx context.Context, cc *tools.CallContext, msg *telegram.NewMessage, meta *audioMeta, language string) (string, error) {
+	if language == config.TranscribeLanguage {
+		if text := storedTranscript(cc.ChatID, msg.ID); text != "" {
+			return text, nil
+		}
+	}
+
+	if limit := time.Duration(config.TranscribeMaxMinutes) * time.Minute; meta.duration > limit {
+		return "", fmt.Errorf("audio is longer than %d minutes", config.TranscribeMaxMinutes)
+	}
+	if reason := quotaExceeded(cc.UserID, cc.ChatID, false); reason != "" {
+		return "", errors.New(reason)
+	}
+
+	att := downloadMedia(cc, msg)
+	if att == nil {
+		return "", errors.New("couldn't download the audio")
+	}
+	mimeType := meta.mimeType
+	if mimeType == "" {
+		mimeType = att.mimeType
+	}
+
+	chunk := time.Duration(config.TranscribeChunkSeconds) * time.Second
+	var pieces []llm.TranscribeRequest
+	switch {
+	case att.path != "" && (att.data == nil || meta.duration > chunk):
+		chunks, err := splitAudio(ctx, cc, att.path)
+		if err != nil {
+			return "", err
+		}
+		for i, data := range chunks {
+			pieces = append(pieces, llm.TranscribeRequest{Audio: data, MIMEType: "audio/mpeg", FileName: fmt.Sprintf("chunk%03d.mp3", i)})
+		}
+	case att.data != nil:
+		// Over quota workspaces can't hold the chunks; send it whole
+		pieces = append(pieces, llm.TranscribeRequest{Audio: att.data, MIMEType: mimeType, FileName: audioFileName(att.fileName, mimeType)})
+	default:
+		return "", errors.New("the audio is too large to transcribe")
+	}
+
+	logger.Printf("Transcribing %s (%s, %s) in %d piece(s) with %s", att.fileName, mimeType, meta.duration, len(pieces), config.TranscribeModel)
+
+	texts := make([]string, 0, len(pieces))
+	for i := range pieces {
+		pieces[i].Language = language
+		reqCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		t, err := provider.Transcribe(reqCtx, config.TranscribeModel, &pieces[i])
+		cancel()
+		if err != nil {
+			return "", fmt.Errorf("transcribe: %w", err)
+		}
+		recordUsage(cc.UserID, cc.ChatID, config.TranscribeModel, t.Usage, 0)
+		if t.Text != "" && t.Text != "[no speech]" {
+			texts = append(texts, t.Text)
+		}
+	}
+
+	text := strings.Join(texts, " ")
+	if text == "" {
+		text = "[no speech]"
+	}
+	if language == config.TranscribeLanguage {
+		saveTranscript(msg, text)
+	}
+	return text, nil
+}
+
+// audioExtensions are the file extensions transcription uploads accept for
+// each audio type.
+var audioExtensions = map[string]string{
+	"audio/ogg":   ".ogg",
+	"audio/opus":  ".ogg",
+	"audio/mpeg":  ".mp3",
+	"audio/mp4":   ".m4a",
+	"audio/x-m4a": ".m4a",
+	"audio/aac":   ".m4a",
+	"audio/wav":   ".wav",
+	"audio/x-wav": ".wav",
+	"audio/webm":  ".webm",
+	"audio/flac":  ".flac",
+}
+
+// audioFileName gives name the extension for mimeType. Telegram's voice
+// notes have no name, and the one gogram makes up can end in any extension
+// registered for the type, e.g. .spx for Ogg.
+func audioFileName(name, mimeType string) string {
+	ext, ok := audioExtensions[mimeType]
+	if !ok || strings.EqualFold(path.Ext(name), ext) {
+		return name
+	}
+	return strings.TrimSuffix(name, path.Ext(name)) + ext
+}
+
+// splitAudio converts a workspace audio file into mono MP3 chunks of
+// TranscribeChunkSeconds with ffmpeg in the sandbox and returns them in
+// order. The chunks are written to the request's scratch folder.
+func splitAudio(ctx context.Context, cc *tools.CallContext, src string) ([][]byte, error) {
+	ws, err := chatWorkspace(cc)
+	if err != nil {
+		return nil, err
+	}
+
+	out := path.Join(strings.TrimPrefix(cc.Scratch, ws.Mount+"/"), fmt.Sprintf(".audio-%d", time.Now().UnixNano()))
+	limits := config.SandboxLimits
+	spec := sandbox.Spec{
+		Image: config.SandboxImage,
+		Cmd: []string{"bash", "-c", `mkdir -p "$OUT" && ffmpeg -hide_banner -loglevel error -nostdin -i "$SRC" ` +
+			`-vn -ac 1 -ar 16000 -c:a libmp3lame -b:a 48k -f segment -segment_time "$CHUNK" "$OUT/%03d.mp3"`},
+		Env: []string{
+			"HOME=/tmp",
+			// Relative to the working directory, so the local driver finds them too
+			"SRC=./" + strings.TrimPrefix(src, ws.Mount+"/"),
+			"OUT=./" + out,
+			"CHUNK=" + strconv.Itoa(config.TranscribeChunkSeconds),
+		},
+		WorkDir: ws.Mount,
+		Mounts:  []sandbox.Mount{chatMount(config.SandboxWorkspaceVolume, ws, false)},
+		Limits: sandbox.Limits{
+			MemoryBytes: int64(limits.MemoryMB) << 20,
+			CPUs:        limits.CPUs,
+			Pids:        int64(limits.Pids),
+			OutputBytes: int64(limits.OutputKB) << 10,
+		},
+		// Re-encoding an hour of audio takes longer than most code runs
+		Timeout: max(limits.Timeout, 3*time.Minute),
+	}
+
+	dir, err := ws.Resolve(out)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	result, err := codeSandbox.Run(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("start sandbox: %w", err)
+	}
+	if result.TimedOut || result.ExitCode != 0 {
+		return nil, fmt.Errorf("ffmpeg failed (exit code %d): %s", result.ExitCode, truncateString(string(result.Stderr), 300))
+	}
+
+	return readChunks(ws, out)
+}
+
+// readChunks reads the files ffmpeg wrote, in name order, through the
+// workspace's checks since the sandbox controls the folder.
+func readChunks(ws *workspace.Workspace, rel string) ([][]byte, error) {
+	dir, err := ws.Resolve(rel)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks [][]byte
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".mp3" {
+			continue
+		}
+		f, info, err := ws.OpenFile(path.Join(ws.Mount, rel, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() > maxMediaSize {
+			f.Close()
+			return nil, fmt.Errorf("audio chunk %s is too large", e.Name())
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, data)
+	}
+	if len(chunks) == 0 {
+		return nil, errors.New("ffmpeg produced no audio")
+	}
+	return chunks, nil
+}
+
+func storedTranscript(chatID int64, msgID int32) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var doc models.Message
+	err := db.Collection("messages").FindOne(ctx, bson.M{
+		"chat_id":    chatID,
+		"msg_id":     msgID,
+		"role":       models.MessageRoleUser,
+		"transcript": bson.M{"$exists": true},
+	}).Decode(&doc)
+	if err != nil {
+		return ""
+	}
+	return doc.Transcript
+}
+
+// saveTranscript attaches a transcript to the message's history entry,
+// creating the entry for messages sent before the bot was recording.
+func saveTranscript(msg *telegram.NewMessage, text string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("messages").UpdateOne(ctx,
+		bson.M{"chat_id": msg.ChatID(), "msg_id": msg.ID, "role": models.MessageRoleUser},
+		bson.M{
+			"$set": bson.M{"transcript": text},
+			"$setOnInsert": bson.M{
+				"sender_id":   msg.SenderID(),
+				"sender_name": getSenderFromMessage(msg),
+				"text":        "[voice]",
+				"created_at":  time.Unix(int64(msg.Date()), 0),
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		logger.Printf("Failed to store transcript of message %d in chat %d: %v", msg.ID, msg.ChatID(), err)
+	}
+}
+
+// handleTranscribe replies with the transcript of the voice note or audio
+// file the command replies to, or is sent with. An optional argument sets
+// the language hint, e.g. "/transcribe te".
+func handleTranscribe(m *telegram.NewMessage) error {
+	chatID := m.ChatID()
+	if wait := checkRate(m.SenderID(), chatID, config.RateText); wait > 0 {
+		m.Reply(fmt.Sprintf("⏳ Easy there! You can transcribe again in %s.", formatWait(wait)))
+		return nil
+	}
+
+	target := m
+	if messageAudio(m) == nil && m.ReplyToMsgID() != 0 {
+		msgs, err := botClient.GetMessages(chatID, &telegram.SearchOption{IDs: []int32{m.ReplyToMsgID()}})
+		if err == nil && len(msgs) > 0 {
+			target = &msgs[0]
+		}
+	}
+	meta := messageAudio(target)
+	if meta == nil {
+		m.Reply("Reply to a voice note or audio file with /transcribe [language].")
+		return nil
+	}
+
+	// Refusals the user can act on are checked here; anything that fails
+	// later is internal and only logged
+	if limit := time.Duration(config.TranscribeMaxMinutes) * time.Minute; meta.duration > limit {
+		m.Reply(fmt.Sprintf("That's too long to transcribe. The limit is %d minutes.", config.TranscribeMaxMinutes))
+		return nil
+	}
+	if reason := quotaExceeded(m.SenderID(), chatID, false); reason != "" {
+		m.Reply(reason)
+		return nil
+	}
+
+	language := strings.TrimSpace(m.Args())
+	if language == "" {
+		language = config.TranscribeLanguage
+	}
+
+	cc := &tools.CallContext{
+		Client:       botClient,
+		Message:      m,
+		ChatID:       chatID,
+		UserID:       m.SenderID(),
+		ReplyToMsgID: m.ID,
+		Permission:   callerPermission(chatID, m.SenderID()),
+	}
+	defer releaseWorkspace(cc)
+
+	status, _ := m.Reply("🎧 Transcribing…")
+	text, err := transcribeMessage(context.Background(), cc, target, meta, language)
+	if err != nil {
+		logger.Printf("Failed to transcribe message %d in chat %d: %v", target.ID, chatID, err)
+		replyStatus(m, status, "❌ Couldn't transcribe that. Try again later.")
+		return nil
+	}
+
+	chunks := splitMessage(fmt.Sprintf("[voice from %s]: %s", getSenderFromMessage(target), text), maxTranscriptMessage)
+	replyStatus(m, status, chunks[0])
+	for _, chunk := range chunks[1:] {
+		m.Reply(chunk)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package aichat
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"testing"
+
+	"github.com/amarnathcjd/gogram/telegram"
+
+	"zeno/config"
+	"zeno/llm"
+	"zeno/tools"
+)
+
+// fakeTranscriber answers Transcribe calls and keeps the requests; the rest
+// of llm.Provider is left unimplemented.
+type fakeTranscriber struct {
+	llm.Provider
+	requests []*llm.TranscribeRequest
+}
+
+func (f *fakeTranscriber) Transcribe(_ context.Context, _ string, req *llm.TranscribeRequest) (*llm.Transcript, error) {
+	f.requests = append(f.requests, req)
+	return &llm.Transcript{Text: "hallo welt"}, nil
+}
+
+func TestTranscribeVoiceNote(t *testing.T) {
+	voice := append([]byte("OggS"), make([]byte, 200)...)
+	fakeFetch(t, voice)
+
+	fake := &fakeTranscriber{}
+	prevProvider, prevOwners, prevMinutes := provider, config.OwnerIDs, config.TranscribeMaxMinutes
+	t.Cleanup(func() { provider, config.OwnerIDs, config.TranscribeMaxMinutes = prevProvider, prevOwners, prevMinutes })
+	provider = fake
+	// Owners skip the quota lookup, which needs the database
+	config.OwnerIDs = []int64{7}
+	config.TranscribeMaxMinutes = 10
+
+	msg := documentMessage(&telegram.DocumentObj{
+		Size:       int64(len(voice)),
+		MimeType:   "audio/ogg",
+		Attributes: []telegram.DocumentAttribute{&telegram.DocumentAttributeAudio{Voice: true, Duration: 4}},
+	})
+	meta := messageAudio(msg)
+	if meta == nil || meta.mimeType != "audio/ogg" || meta.duration.Seconds() != 4 {
+		t.Fatalf("messageAudio = %+v", meta)
+	}
+
+	// A language other than the configured default skips the stored
+	// transcripts
+	cc := &tools.CallContext{ChatID: 1, UserID: 7}
+	text, err := transcribeMessage(context.Background(), cc, msg, meta, "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "hallo welt" {
+		t.Errorf("transcript = %q", text)
+	}
+
+	if len(fake.requests) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(fake.requests))
+	}
+	req := fake.requests[0]
+	if !bytes.Equal(req.Audio, voice) {
+		t.Errorf("sent %d bytes of audio, want the %d downloaded", len(req.Audio), len(voice))
+	}
+	if req.MIMEType != "audio/ogg" || req.Language != "de" {
+		t.Errorf("sent %s in %q, want audio/ogg in \"de\"", req.MIMEType, req.Language)
+	}
+	// Providers that take an upload go by the extension
+	if path.Ext(req.FileName) != ".ogg" {
+		t.Errorf("file name %q doesn't end in .ogg", req.FileName)
+	}
+}
+
+func TestAudioFileName(t *testing.T) {
+	tests := []struct{ name, mime, want string }{
+		{"file_1.spx", "audio/ogg", "file_1.ogg"},
+		{"file_1.oga", "audio/ogg", "file_1.ogg"},
+		{"voice.OGG", "audio/ogg", "voice.OGG"},
+		{"song.mp3", "audio/mpeg", "song.mp3"},
+		{"file", "audio/mp4", "file.m4a"},
+		{"talk.v1.amr", "audio/amr", "talk.v1.amr"},
+	}
+	for _, tt := range tests {
+		if got := audioFileName(tt.name, tt.mime); got != tt.want {
+			t.Errorf("audioFileName(%q, %s) = %q, want %q", tt.name, tt.mime, got, tt.want)
+		}
+	}
+}
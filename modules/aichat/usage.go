@@ -0,0 +1,246 @@
+package aichat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/genai"
+
+	"zeno/config"
+	"zeno/db"
+	"zeno/models"
+)
+
+type usageTotals struct {
+	Tokens int64 `bson:"tokens"`
+	Images int64 `bson:"images"`
+}
+
+func ensureUsageIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := db.Collection("usage").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// recordUsage adds one entry to the usage ledger. meta may be nil when the
+// provider reports no token counts.
+func recordUsage(userID, chatID int64, model string, meta *genai.GenerateContentResponseUsageMetadata, images int) {
+	doc := models.UsageRecord{
+		UserID:    userID,
+		ChatID:    chatID,
+		Model:     model,
+		Images:    int64(images),
+		CreatedAt: time.Now(),
+	}
+	if meta != nil {
+		doc.PromptTokens = int64(meta.PromptTokenCount) + int64(meta.ToolUsePromptTokenCount)
+		doc.OutputTokens = int64(meta.CandidatesTokenCount)
+		doc.ThoughtTokens = int64(meta.ThoughtsTokenCount)
+	}
+	if doc.PromptTokens == 0 && doc.OutputTokens == 0 && doc.ThoughtTokens == 0 && doc.Images == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Collection("usage").InsertOne(ctx, doc); err != nil {
+		logger.Printf("Failed to record usage for user %d in chat %d: %v", userID, chatID, err)
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// sumUsage totals tokens and images for ledger entries matching filter since
+// the given time.
+func sumUsage(ctx context.Context, filter bson.M, since time.Time) (usageTotals, error) {
+	match := bson.M{"created_at": bson.M{"$gte": since}}
+	for k, v := range filter {
+		match[k] = v
+	}
+
+	cursor, err := db.Collection("usage").Aggregate(ctx, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id":    nil,
+			"tokens": bson.M{"$sum": bson.M{"$add": bson.A{"$prompt_tokens", "$output_tokens", "$thought_tokens"}}},
+			"images": bson.M{"$sum": "$images"},
+		}},
+	})
+	if err != nil {
+		return usageTotals{}, err
+	}
+
+	var totals []usageTotals
+	if err := cursor.All(ctx, &totals); err != nil || len(totals) == 0 {
+		return usageTotals{}, err
+	}
+	return totals[0], nil
+}
+
+// quotaExceeded checks the user's and chat's usage against the configured
+// quotas. It returns a reason to show the user, or "" when within limits.
+// Owners are never limited.
+func quotaExceeded(userID, chatID int64, images bool) string {
+	_, reason := quotaRemaining(userID, chatID, images)
+	return reason
+}
+
+// quotaRemaining returns how much of the tightest quota is left, or -1 when
+// nothing limits the caller, along with the reason to show once it's used up.
+func quotaRemaining(userID, chatID int64, images bool) (int64, string) {
+	if config.IsOwner(userID) {
+		return -1, ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	checks := []struct {
+		scope  string
+		filter bson.M
+		tokens config.Quota
+		images config.Quota
+	}{
+		{"your", bson.M{"user_id": userID}, config.UserTokenQuota, config.UserImageQuota},
+		{"this chat's", bson.M{"chat_id": chatID}, config.ChatTokenQuota, config.ChatImageQuota},
+	}
+
+	remaining := int64(-1)
+	for _, c := range checks {
+		quota := c.tokens
+		unit := "token"
+		if images {
+			quota = c.images
+			unit = "image"
+		}
+
+		periods := []struct {
+			name  string
+			limit int64
+			since time.Time
+		}{
+			{"daily", quota.Daily, startOfDay(now)},
+			{"monthly", quota.Monthly, startOfMonth(now)},
+		}
+		for _, p := range periods {
+			if p.limit <= 0 {
+				continue
+			}
+			totals, err := sumUsage(ctx, c.filter, p.since)
+			if err != nil {
+				// Don't block requests because the ledger is unavailable
+				logger.Printf("Failed to check %s quota: %v", p.name, err)
+				continue
+			}
+			used := totals.Tokens
+			if images {
+				used = totals.Images
+			}
+			if used >= p.limit {
+				return 0, fmt.Sprintf("You've hit %s %s %s quota (%d/%d). Try again later.", c.scope, p.name, unit, used, p.limit)
+			}
+			if left := p.limit - used; remaining < 0 || left < remaining {
+				remaining = left
+			}
+		}
+	}
+
+	return remaining, ""
+}
+
+func formatLimit(limit int64) string {
+	if limit <= 0 {
+		return "∞"
+	}
+	return fmt.Sprintf("%d", limit)
+}
+
+func handleUsage(m *telegram.NewMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID := m.SenderID()
+	chatID := m.ChatID()
+	now := time.Now()
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📊 **Usage for %s** (UTC)\n\n", getSenderName(m)))
+
+	rows := []struct {
+		label  string
+		filter bson.M
+		since  time.Time
+		tokens int64
+		images int64
+	}{
+		{"You today", bson.M{"user_id": userID}, startOfDay(now), config.UserTokenQuota.Daily, config.UserImageQuota.Daily},
+		{"You this month", bson.M{"user_id": userID}, startOfMonth(now), config.UserTokenQuota.Monthly, config.UserImageQuota.Monthly},
+		{"Chat today", bson.M{"chat_id": chatID}, startOfDay(now), config.ChatTokenQuota.Daily, config.ChatImageQuota.Daily},
+		{"Chat this month", bson.M{"chat_id": chatID}, startOfMonth(now), config.ChatTokenQuota.Monthly, config.ChatImageQuota.Monthly},
+	}
+	for _, r := range rows {
+		totals, err := sumUsage(ctx, r.filter, r.since)
+		if err != nil {
+			logger.Printf("Failed to load usage: %v", err)
+			m.Reply("Couldn't load usage. Try again later.")
+			return nil
+		}
+		sb.WriteString(fmt.Sprintf("%s: `%d/%s` tokens, `%d/%s` images\n",
+			r.label, totals.Tokens, formatLimit(r.tokens), totals.Images, formatLimit(r.images)))
+	}
+
+	// Per-model breakdown for the user this month
+	cursor, err := db.Collection("usage").Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"user_id": userID, "created_at": bson.M{"$gte": startOfMonth(now)}}},
+		bson.M{"$group": bson.M{
+			"_id":     "$model",
+			"prompt":  bson.M{"$sum": "$prompt_tokens"},
+			"output":  bson.M{"$sum": "$output_tokens"},
+			"thought": bson.M{"$sum": "$thought_tokens"},
+			"images":  bson.M{"$sum": "$images"},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	})
+	if err == nil {
+		var byModel []struct {
+			Model   string `bson:"_id"`
+			Prompt  int64  `bson:"prompt"`
+			Output  int64  `bson:"output"`
+			Thought int64  `bson:"thought"`
+			Images  int64  `bson:"images"`
+		}
+		if cursor.All(ctx, &byModel) == nil && len(byModel) > 0 {
+			sb.WriteString("\n**Your models this month**\n")
+			for _, u := range byModel {
+				sb.WriteString(fmt.Sprintf("- `%s`: %d in / %d out / %d thought", u.Model, u.Prompt, u.Output, u.Thought))
+				if u.Images > 0 {
+					sb.WriteString(fmt.Sprintf(", %d images", u.Images))
+				}
+				sb.WriteString("\n")
+			}
+		}
+	}
+
+	m.Reply(sb.String(), &telegram.SendOptions{ParseMode: "Markdown"})
+	return nil
+}
@@ -0,0 +1,274 @@
+package aichat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"zeno/config"
+	"zeno/db"
+	"zeno/sandbox"
+	"zeno/tools"
+	"zeno/workspace"
+)
+
+// Paths the model uses for a chat's files, both in run_code and send_file.
+const (
+	workspaceMount = "/workspace"
+	generatedMount = "/generated"
+)
+
+var (
+	workspaces      *workspace.Manager
+	generatedImages *workspace.Manager
+)
+
+func initWorkspaces() {
+	workspaces = workspace.NewManager(config.WorkspaceRoot, workspaceMount, int64(config.WorkspaceQuotaMB)<<20)
+	generatedImages = workspace.NewManager(GeneratedImagesDir, generatedMount, 0)
+}
+
+// chatWorkspace opens the caller's workspace on first use in a request,
+// along with the request's scratch folder.
+func chatWorkspace(cc *tools.CallContext) (*workspace.Workspace, error) {
+	if cc.Workspace != nil {
+		return cc.Workspace, nil
+	}
+
+	ws, err := workspaces.Open(cc.ChatID)
+	if err != nil {
+		return nil, err
+	}
+	scratch, err := ws.Scratch(scratchName(cc))
+	if err != nil {
+		return nil, fmt.Errorf("create scratch folder: %w", err)
+	}
+	cc.Workspace = ws
+	cc.Scratch = scratch
+
+	recordWorkspace(ws, cc.UserID)
+	return ws, nil
+}
+
+// releaseWorkspace removes the request's scratch folder, if one was made.
+func releaseWorkspace(cc *tools.CallContext) {
+	if cc.Workspace == nil {
+		return
+	}
+	if err := cc.Workspace.RemoveScratch(scratchName(cc)); err != nil {
+		logger.Printf("Failed to remove scratch folder in chat %d: %v", cc.ChatID, err)
+	}
+}
+
+func scratchName(cc *tools.CallContext) string {
+	return strconv.Itoa(int(cc.ReplyToMsgID))
+}
+
+// recordWorkspace stores the workspace's owner on first use and refreshes
+// its usage.
+func recordWorkspace(ws *workspace.Workspace, userID int64) {
+	used, err := ws.Usage()
+	if err != nil {
+		logger.Printf("Failed to measure workspace of chat %d: %v", ws.ChatID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err = db.Collection("workspaces").UpdateOne(ctx,
+		bson.M{"_id": ws.ChatID},
+		bson.M{
+			"$setOnInsert": bson.M{"path": ws.Subpath, "created_by": userID, "created_at": now},
+			"$set":         bson.M{"quota_bytes": ws.QuotaBytes, "used_bytes": used, "last_used_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		logger.Printf("Failed to record workspace of chat %d: %v", ws.ChatID, err)
+	}
+}
+
+// chatFolder picks the folder of the caller's chat that a path from the
+// model points into: its generated images or its workspace.
+func chatFolder(cc *tools.CallContext, p string) (*workspace.Workspace, error) {
+	p = strings.TrimSpace(p)
+	if p == generatedMount || strings.HasPrefix(p, generatedMount+"/") {
+		return generatedImages.Open(cc.ChatID)
+	}
+	return chatWorkspace(cc)
+}
+
+// openSendable opens a file for send_file. The path must stay inside the
+// chat's folders after following symlinks, and the file must fit the size
+// and type limits. Refusals are logged, as they may come from a prompt
+// injection trying to read the bot's own files.
+func openSendable(cc *tools.CallContext, p string) (*os.File, string, error) {
+	blocked := func(reason error) (*os.File, string, error) {
+		logger.Printf("Blocked send_file of %q in chat %d by user %d: %v", p, cc.ChatID, cc.UserID, reason)
+		return nil, "", reason
+	}
+
+	folder, err := chatFolder(cc, p)
+	if err != nil {
+		return nil, "", err
+	}
+	f, info, err := folder.OpenFile(p)
+	switch {
+	case errors.Is(err, workspace.ErrOutside):
+		return blocked(errors.New("only files in this chat's /workspace/ or /generated/ can be sent"))
+	case errors.Is(err, workspace.ErrNotRegular):
+		return blocked(errors.New("not a regular file"))
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, "", errors.New("file not found")
+	case err != nil:
+		// The error holds host paths, which the model shouldn't see
+		logger.Printf("Failed to open %q for send_file in chat %d: %v", p, cc.ChatID, err)
+		return nil, "", errors.New("couldn't read the file")
+	}
+
+	if limit := int64(config.SendFileMaxMB) << 20; info.Size() > limit {
+		f.Close()
+		return blocked(fmt.Errorf("file is %s, over the %d MB limit", formatMB(info.Size()), config.SendFileMaxMB))
+	}
+
+	mimeType := fileType(f, info.Name())
+	if !typeAllowed(mimeType, config.SendFileTypes) {
+		f.Close()
+		return blocked(fmt.Errorf("files of type %s can't be sent", mimeType))
+	}
+	return f, mimeType, nil
+}
+
+// fileType guesses a file's MIME type from its extension, or from its first
+// bytes when the extension is unknown.
+func fileType(f *os.File, name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		t, _, _ = strings.Cut(t, ";")
+		return t
+	}
+	head := make([]byte, 512)
+	n, _ := f.ReadAt(head, 0)
+	t, _, _ := strings.Cut(http.DetectContentType(head[:n]), ";")
+	return t
+}
+
+func typeAllowed(mimeType string, allowed []string) bool {
+	for _, pattern := range allowed {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(mimeType, prefix) {
+				return true
+			}
+		} else if strings.EqualFold(mimeType, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// chatMount mounts a chat's directory of a volume into the sandbox. Host
+// paths are bind mounted directly; named volumes use a subpath.
+func chatMount(volume string, ws *workspace.Workspace, readOnly bool) sandbox.Mount {
+	if filepath.IsAbs(volume) {
+		return sandbox.Mount{Source: filepath.Join(volume, filepath.FromSlash(ws.Subpath)), Target: ws.Mount, ReadOnly: readOnly}
+	}
+	return sandbox.Mount{Source: volume, Subpath: ws.Subpath, Target: ws.Mount, ReadOnly: readOnly}
+}
+
+func formatMB(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
+
+func handleWorkspace(m *telegram.NewMessage) error {
+	chatID := m.ChatID()
+	ws, err := workspaces.Open(chatID)
+	if err != nil {
+		logger.Printf("Failed to open workspace of chat %d: %v", chatID, err)
+		m.Reply("Couldn't open this chat's workspace.")
+		return nil
+	}
+
+	args := strings.Fields(strings.ToLower(m.Args()))
+	if len(args) == 0 {
+		args = []string{""}
+	}
+
+	switch args[0] {
+	case "":
+		used, err := ws.Usage()
+		if err != nil {
+			logger.Printf("Failed to measure workspace of chat %d: %v", chatID, err)
+		}
+		quota := "unlimited"
+		if ws.QuotaBytes > 0 {
+			quota = formatMB(ws.QuotaBytes)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		autoSend := "off"
+		if getChatSettings(ctx, chatID).AutoSendArtifacts {
+			autoSend = "on"
+		}
+		cancel()
+		m.Reply(fmt.Sprintf("🗂 **Workspace**\n\nUsed: %s of %s\nAuto-send of run_code files: %s\n\n"+
+			"Admins: `/workspace clear` deletes all files, `/workspace autosend on|off` toggles auto-send.", formatMB(used), quota, autoSend),
+			&telegram.SendOptions{ParseMode: "Markdown"})
+
+	case "autosend":
+		if !isChatAdmin(chatID, m.SenderID()) {
+			m.Reply("Only chat admins can change auto-send.")
+			return nil
+		}
+		if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+			m.Reply("Usage: /workspace autosend <on|off>")
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := db.Collection("chat_settings").UpdateOne(ctx,
+			bson.M{"_id": chatID},
+			bson.M{"$set": bson.M{"auto_send_artifacts": args[1] == "on"}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			logger.Printf("Failed to set auto-send for chat %d: %v", chatID, err)
+			m.Reply("Couldn't change auto-send. Try again later.")
+			return nil
+		}
+		m.Reply(fmt.Sprintf("Auto-send of run_code files turned %s.", args[1]))
+
+	case "clear":
+		if !isChatAdmin(chatID, m.SenderID()) {
+			m.Reply("Only chat admins can clear the workspace.")
+			return nil
+		}
+		if err := ws.Clear(); err != nil {
+			logger.Printf("Failed to clear workspace of chat %d: %v", chatID, err)
+			m.Reply("Couldn't clear the workspace. Try again later.")
+			return nil
+		}
+		recordWorkspace(ws, m.SenderID())
+		m.Reply("Workspace cleared.")
+
+	default:
+		m.Reply("Usage: /workspace [clear|autosend <on|off>]")
+	}
+	return nil
+}
+
+// quotaError explains a full workspace to the model.
+func quotaError(ws *workspace.Workspace, used int64) map[string]any {
+	return tools.Error(fmt.Sprintf("The chat's workspace is full (%s of %s). Ask an admin to free space with /workspace clear.",
+		formatMB(used), formatMB(ws.QuotaBytes)))
+}
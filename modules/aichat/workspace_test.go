@@ -0,0 +1,76 @@
+package aichat
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"zeno/tools"
+	"zeno/workspace"
+)
+
+func TestChatFolder(t *testing.T) {
+	root := t.TempDir()
+	workspaces = workspace.NewManager(filepath.Join(root, "workspaces"), workspaceMount, 0)
+	generatedImages = workspace.NewManager(filepath.Join(root, "generated"), generatedMount, 0)
+	t.Cleanup(func() { workspaces, generatedImages = nil, nil })
+
+	ws, err := workspaces.Open(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := generatedImages.Open(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := generatedImages.Open(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, name := range map[string]string{ws.Dir: "data.csv", gen.Dir: "img_1.png", other.Dir: "img_2.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A workspace already open on the request skips the database
+	cc := &tools.CallContext{ChatID: 1, Workspace: ws}
+
+	tests := []struct {
+		path string
+		dir  string
+		err  error
+	}{
+		{path: "/generated/img_1.png", dir: gen.Dir},
+		{path: " /generated/img_1.png", dir: gen.Dir},
+		{path: "/workspace/data.csv", dir: ws.Dir},
+		{path: "data.csv", dir: ws.Dir},
+		// Neither folder reaches into the other or another chat's images
+		{path: "/workspace/../generated/img_1.png", dir: ws.Dir, err: workspace.ErrOutside},
+		{path: "/generated/../../chats/2/img_2.png", dir: gen.Dir, err: workspace.ErrOutside},
+		{path: "/generated/../workspace/data.csv", dir: gen.Dir, err: workspace.ErrOutside},
+		{path: "/generatedx/img_1.png", dir: ws.Dir, err: workspace.ErrOutside},
+		{path: "generated/img_1.png", dir: ws.Dir, err: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		folder, err := chatFolder(cc, tt.path)
+		if err != nil {
+			t.Fatalf("chatFolder(%q): %v", tt.path, err)
+		}
+		if folder.Dir != tt.dir {
+			t.Errorf("chatFolder(%q) = %s, want %s", tt.path, folder.Dir, tt.dir)
+		}
+
+		f, _, err := folder.OpenFile(tt.path)
+		if f != nil {
+			f.Close()
+		}
+		if tt.err == nil && err != nil {
+			t.Errorf("OpenFile(%q): %v", tt.path, err)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("OpenFile(%q) err = %v, want %v", tt.path, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Package module defines the lifecycle shared by all bot modules and the
+// registry that drives it.
+package module
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"zeno/config"
+)
+
+// Deps are the shared dependencies handed to every module on Init.
+// Settings that are not listed here are read from the config package.
+type Deps struct {
+	Client      *telegram.Client
+	DB          *mongo.Database
+	Logger      *log.Logger
+	BotID       int64
+	BotUsername string
+}
+
+// Module is a self-contained feature of the bot. Init wires dependencies and
+// may fail; Start registers handlers and starts background work; Stop undoes
+// Start. Modules are started in registration order and stopped in reverse.
+type Module interface {
+	Name() string
+	Init(deps Deps) error
+	Start() error
+	Stop() error
+}
+
+type Registry struct {
+	deps    Deps
+	modules []Module
+	started []Module
+}
+
+func NewRegistry(deps Deps) *Registry {
+	return &Registry{deps: deps}
+}
+
+// Register adds a module unless it is disabled in config.
+func (r *Registry) Register(m Module) {
+	if !config.ModuleEnabled(m.Name()) {
+		log.Printf("[Modules] %s disabled by config", m.Name())
+		return
+	}
+	r.modules = append(r.modules, m)
+}
+
+// Start initializes every registered module and then starts them. If any
+// module fails, the ones already started are stopped again.
+func (r *Registry) Start() error {
+	for _, m := range r.modules {
+		deps := r.deps
+		deps.Logger = log.New(log.Writer(), fmt.Sprintf("[%s] ", m.Name()), log.Flags()|log.Lmsgprefix)
+		if err := m.Init(deps); err != nil {
+			return fmt.Errorf("init %s: %w", m.Name(), err)
+		}
+	}
+
+	for _, m := range r.modules {
+		if err := m.Start(); err != nil {
+			r.Stop()
+			return fmt.Errorf("start %s: %w", m.Name(), err)
+		}
+		r.started = append(r.started, m)
+		log.Printf("[Modules] %s started", m.Name())
+	}
+	return nil
+}
+
+// Stop stops started modules in reverse order.
+func (r *Registry) Stop() {
+	for i := len(r.started) - 1; i >= 0; i-- {
+		m := r.started[i]
+		if err := m.Stop(); err != nil {
+			log.Printf("[Modules] Error stopping %s: %v", m.Name(), err)
+			continue
+		}
+		log.Printf("[Modules] %s stopped", m.Name())
+	}
+	r.started = nil
+}
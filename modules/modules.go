@@ -1,11 +1,13 @@
 package modules
 
 import (
-	"github.com/amarnathcjd/gogram/telegram"
-
+	"zeno/modules/access"
 	"zeno/modules/aichat"
+	"zeno/modules/module"
 )
 
-func RegisterAll(client *telegram.Client) {
-	aichat.Register(client)
+// RegisterAll adds the built-in modules to the registry in start order.
+func RegisterAll(r *module.Registry) {
+	r.Register(access.New())
+	r.Register(aichat.New())
 }
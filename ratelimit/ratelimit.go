@@ -0,0 +1,148 @@
+// Package ratelimit implements in-memory token buckets keyed by string.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limit allows Count events per Per, with bursts of up to Count. A zero
+// Count disables the limit.
+type Limit struct {
+	Count int
+	Per   time.Duration
+}
+
+func (l Limit) Disabled() bool {
+	return l.Count <= 0 || l.Per <= 0
+}
+
+type bucket struct {
+	tokens   float64
+	updated  time.Time
+	capacity float64
+	per      time.Duration
+}
+
+// Buckets are swept at most this often. Only full buckets are dropped, since
+// a new bucket starts full anyway.
+const idleTTL = time.Hour
+
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Check is one bucket to draw from.
+type Check struct {
+	Key   string
+	Limit Limit
+}
+
+// Allow takes one token from every bucket, or from none of them. When any
+// bucket is empty it returns false and how long until all have a token.
+func (l *Limiter) Allow(checks ...Check) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var wait time.Duration
+	active := make([]*bucket, 0, len(checks))
+	for _, c := range checks {
+		if c.Limit.Disabled() {
+			continue
+		}
+		b := l.refill(c.Key, c.Limit, now)
+		active = append(active, b)
+		if b.tokens < 1 {
+			rate := float64(c.Limit.Count) / float64(c.Limit.Per)
+			if w := time.Duration((1 - b.tokens) / rate); w > wait {
+				wait = w
+			}
+		}
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range active {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// TakeUpTo takes as many tokens as every bucket can spare, up to n, and
+// returns how many it took. Disabled limits don't restrict the count.
+func (l *Limiter) TakeUpTo(n int, checks ...Check) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	active := make([]*bucket, 0, len(checks))
+	for _, c := range checks {
+		if c.Limit.Disabled() {
+			continue
+		}
+		b := l.refill(c.Key, c.Limit, now)
+		active = append(active, b)
+		n = min(n, int(b.tokens))
+	}
+
+	n = max(n, 0)
+	for _, b := range active {
+		b.tokens -= float64(n)
+	}
+	return n
+}
+
+func (l *Limiter) refill(key string, limit Limit, now time.Time) *bucket {
+	capacity := float64(limit.Count)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now, capacity: capacity, per: limit.Per}
+		l.buckets[key] = b
+		return b
+	}
+
+	// Limits can change at runtime; clamp to the current capacity
+	b.capacity = capacity
+	b.per = limit.Per
+	elapsed := now.Sub(b.updated)
+	b.tokens += float64(elapsed) * capacity / float64(limit.Per)
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.updated = now
+	return b
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTTL {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) > idleTTL && b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// full reports whether the bucket will have refilled by now.
+func (b *bucket) full(now time.Time) bool {
+	refilled := float64(now.Sub(b.updated)) * b.capacity / float64(b.per)
+	return b.tokens+refilled >= b.capacity
+}
@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock drives a Limiter's time in tests.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter() (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New()
+	l.now = clock.now
+	return l, clock
+}
+
+func TestAllowBurst(t *testing.T) {
+	l, _ := newTestLimiter()
+	check := Check{Key: "k", Limit: Limit{Count: 3, Per: time.Minute}}
+
+	for i := range 3 {
+		if ok, _ := l.Allow(check); !ok {
+			t.Fatalf("request %d was limited within the burst", i+1)
+		}
+	}
+	if ok, wait := l.Allow(check); ok {
+		t.Fatal("request after the burst was allowed")
+	} else if wait != 20*time.Second {
+		t.Errorf("wait = %s, want 20s", wait)
+	}
+}
+
+func TestAllowRefill(t *testing.T) {
+	l, clock := newTestLimiter()
+	check := Check{Key: "k", Limit: Limit{Count: 2, Per: time.Minute}}
+
+	l.Allow(check)
+	l.Allow(check)
+
+	clock.advance(10 * time.Second)
+	ok, wait := l.Allow(check)
+	if ok {
+		t.Fatal("allowed before a token refilled")
+	}
+	if wait != 20*time.Second {
+		t.Errorf("wait = %s, want 20s", wait)
+	}
+
+	clock.advance(20 * time.Second)
+	if ok, _ := l.Allow(check); !ok {
+		t.Fatal("limited after a token refilled")
+	}
+	if ok, _ := l.Allow(check); ok {
+		t.Fatal("refill went past one token")
+	}
+}
+
+func TestAllowAllOrNothing(t *testing.T) {
+	l, _ := newTestLimiter()
+	user := Check{Key: "user", Limit: Limit{Count: 5, Per: time.Minute}}
+	chat := Check{Key: "chat", Limit: Limit{Count: 1, Per: time.Minute}}
+
+	if ok, _ := l.Allow(user, chat); !ok {
+		t.Fatal("first request was limited")
+	}
+	if ok, _ := l.Allow(user, chat); ok {
+		t.Fatal("allowed with the chat bucket empty")
+	}
+	// The refused request mustn't have taken a user token
+	if got := l.buckets["user"].tokens; got != 4 {
+		t.Errorf("user tokens = %v, want 4", got)
+	}
+}
+
+func TestAllowDisabled(t *testing.T) {
+	l, _ := newTestLimiter()
+	for range 100 {
+		if ok, _ := l.Allow(Check{Key: "k", Limit: Limit{}}); !ok {
+			t.Fatal("disabled limit refused a request")
+		}
+	}
+	if len(l.buckets) != 0 {
+		t.Errorf("disabled limit created %d buckets", len(l.buckets))
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l, clock := newTestLimiter()
+	hourly := Check{Key: "hourly", Limit: Limit{Count: 2, Per: time.Minute}}
+	daily := Check{Key: "daily", Limit: Limit{Count: 2, Per: 24 * time.Hour}}
+
+	l.Allow(hourly, daily)
+	l.Allow(hourly, daily)
+
+	clock.advance(2 * time.Hour)
+	l.Allow(Check{Key: "other", Limit: Limit{Count: 1, Per: time.Minute}})
+
+	if _, ok := l.buckets["hourly"]; ok {
+		t.Error("refilled bucket wasn't swept")
+	}
+	if _, ok := l.buckets["daily"]; !ok {
+		t.Fatal("daily bucket was swept before it refilled")
+	}
+	if ok, _ := l.Allow(daily); ok {
+		t.Error("daily limit came back full after two idle hours")
+	}
+}
+
+func TestTakeUpTo(t *testing.T) {
+	l, clock := newTestLimiter()
+	user := Check{Key: "user", Limit: Limit{Count: 5, Per: time.Minute}}
+	chat := Check{Key: "chat", Limit: Limit{Count: 3, Per: time.Minute}}
+
+	if got := l.TakeUpTo(4, user, chat); got != 3 {
+		t.Errorf("took %d, want 3 limited by the chat bucket", got)
+	}
+	if got := l.TakeUpTo(4, user, chat); got != 0 {
+		t.Errorf("took %d from empty buckets", got)
+	}
+	if got := l.buckets["user"].tokens; got != 2 {
+		t.Errorf("user tokens = %v, want 2", got)
+	}
+
+	clock.advance(30 * time.Second)
+	if got := l.TakeUpTo(4, user, chat); got != 1 {
+		t.Errorf("took %d after a partial refill, want 1", got)
+	}
+	if got := l.TakeUpTo(2, Check{Key: "off", Limit: Limit{}}); got != 2 {
+		t.Errorf("took %d with the limit disabled, want 2", got)
+	}
+}
@@ -0,0 +1,290 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Label set on every container the driver creates, so leftovers from a
+// crash can be found and removed.
+const containerLabel = "zeno.sandbox"
+
+// Docker runs each Spec in a new container through the Engine API on a unix
+// socket. No docker CLI is needed.
+type Docker struct {
+	client *http.Client
+}
+
+func NewDocker(socket string) *Docker {
+	return &Docker{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (d *Docker) Name() string {
+	return "docker"
+}
+
+type apiError struct {
+	Status  int
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("docker API %d: %s", e.Status, e.Message)
+}
+
+// request sends a request to the Engine API and returns the response when
+// its status is successful.
+func (d *Docker) request(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	u := "http://docker" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		apiErr := &apiError{Status: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// do sends a request and decodes the JSON response into out when given.
+func (d *Docker) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := d.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	// Streams such as pull progress end when the operation does
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+type dockerMount struct {
+	Type          string               `json:"Type"`
+	Source        string               `json:"Source"`
+	Target        string               `json:"Target"`
+	ReadOnly      bool                 `json:"ReadOnly"`
+	VolumeOptions *dockerVolumeOptions `json:"VolumeOptions,omitempty"`
+}
+
+type dockerVolumeOptions struct {
+	Subpath string `json:"Subpath,omitempty"`
+}
+
+func (d *Docker) Run(ctx context.Context, spec Spec) (*Result, error) {
+	mounts := make([]dockerMount, 0, len(spec.Mounts))
+	for _, m := range spec.Mounts {
+		dm := dockerMount{Type: "volume", Source: m.Source, Target: m.Target, ReadOnly: m.ReadOnly}
+		if len(m.Source) > 0 && m.Source[0] == '/' {
+			dm.Type = "bind"
+		} else if m.Subpath != "" {
+			dm.VolumeOptions = &dockerVolumeOptions{Subpath: m.Subpath}
+		}
+		mounts = append(mounts, dm)
+	}
+
+	network := "none"
+	if spec.Limits.Network {
+		network = "bridge"
+	}
+	hostConfig := map[string]any{
+		"NetworkMode":    network,
+		"Mounts":         mounts,
+		"ReadonlyRootfs": true,
+		"Tmpfs":          map[string]string{"/tmp": "rw,size=100m"},
+		"CapDrop":        []string{"ALL"},
+		"SecurityOpt":    []string{"no-new-privileges"},
+		// Output is read back through the logs endpoint. The log file is
+		// capped so a chatty run can't fill the host's disk; past the cap
+		// only the latest output is kept, and the run is reported as
+		// truncated either way
+		"LogConfig": map[string]any{
+			"Type":   "json-file",
+			"Config": map[string]string{"max-size": "1m", "max-file": "1"},
+		},
+	}
+	if spec.Limits.MemoryBytes > 0 {
+		hostConfig["Memory"] = spec.Limits.MemoryBytes
+		hostConfig["MemorySwap"] = spec.Limits.MemoryBytes
+	}
+	if spec.Limits.CPUs > 0 {
+		hostConfig["NanoCpus"] = int64(spec.Limits.CPUs * 1e9)
+	}
+	if spec.Limits.Pids > 0 {
+		hostConfig["PidsLimit"] = spec.Limits.Pids
+	}
+
+	create := map[string]any{
+		"Image":           spec.Image,
+		"Cmd":             spec.Cmd,
+		"Env":             spec.Env,
+		"WorkingDir":      spec.WorkDir,
+		"NetworkDisabled": !spec.Limits.Network,
+		"Labels":          map[string]string{containerLabel: "1"},
+		"HostConfig":      hostConfig,
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := d.do(ctx, http.MethodPost, "/containers/create", nil, create, &created)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		// The image isn't there yet; pull it once and retry
+		if err := d.pull(ctx, spec.Image); err != nil {
+			return nil, fmt.Errorf("pull %s: %w", spec.Image, err)
+		}
+		err = d.do(ctx, http.MethodPost, "/containers/create", nil, create, &created)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create container: %w", err)
+	}
+
+	// Removal must happen even when ctx is cancelled or the run timed out
+	defer func() {
+		rmCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		d.do(rmCtx, http.MethodDelete, "/containers/"+created.ID, url.Values{"force": {"1"}, "v": {"1"}}, nil, nil)
+	}()
+
+	start := time.Now()
+	if err := d.do(ctx, http.MethodPost, "/containers/"+created.ID+"/start", nil, nil, nil); err != nil {
+		return nil, fmt.Errorf("start container: %w", err)
+	}
+
+	runCtx := ctx
+	if spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, spec.Timeout)
+		defer cancel()
+	}
+
+	result := &Result{}
+	var waited struct {
+		StatusCode int `json:"StatusCode"`
+	}
+	err = d.do(runCtx, http.MethodPost, "/containers/"+created.ID+"/wait", nil, nil, &waited)
+	result.Duration = time.Since(start)
+	if err != nil {
+		if runCtx.Err() == nil {
+			return nil, fmt.Errorf("wait for container: %w", err)
+		}
+		result.TimedOut = true
+		killCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		d.do(killCtx, http.MethodPost, "/containers/"+created.ID+"/kill", nil, nil, nil)
+		cancel()
+		result.ExitCode = -1
+	} else {
+		result.ExitCode = waited.StatusCode
+	}
+
+	// Logs and state are read with a fresh context so a timed-out run still
+	// reports what it printed
+	readCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stdout := &cappedBuffer{limit: spec.Limits.OutputBytes}
+	stderr := &cappedBuffer{limit: spec.Limits.OutputBytes}
+	query := url.Values{"stdout": {"1"}, "stderr": {"1"}}
+	if resp, err := d.request(readCtx, http.MethodGet, "/containers/"+created.ID+"/logs", query, nil); err == nil {
+		demux(resp.Body, stdout, stderr)
+		resp.Body.Close()
+	}
+	result.Stdout, result.StdoutBytes = stdout.buf.Bytes(), stdout.total
+	result.Stderr, result.StderrBytes = stderr.buf.Bytes(), stderr.total
+
+	var state struct {
+		State struct {
+			OOMKilled bool `json:"OOMKilled"`
+		} `json:"State"`
+	}
+	if err := d.do(readCtx, http.MethodGet, "/containers/"+created.ID+"/json", nil, nil, &state); err == nil {
+		result.OOMKilled = state.State.OOMKilled
+	}
+
+	return result, nil
+}
+
+func (d *Docker) pull(ctx context.Context, image string) error {
+	return d.do(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {image}}, nil, nil)
+}
+
+// Prune removes containers left behind by a previous process, e.g. after a
+// crash between create and cleanup.
+func (d *Docker) Prune(ctx context.Context) error {
+	filters, _ := json.Marshal(map[string][]string{"label": {containerLabel}})
+	var containers []struct {
+		ID string `json:"Id"`
+	}
+	err := d.do(ctx, http.MethodGet, "/containers/json", url.Values{"all": {"1"}, "filters": {string(filters)}}, nil, &containers)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		d.do(ctx, http.MethodDelete, "/containers/"+c.ID, url.Values{"force": {"1"}, "v": {"1"}}, nil, nil)
+	}
+	return nil
+}
+
+// demux splits Docker's multiplexed log stream, where each frame has an
+// 8-byte header holding the stream type and payload length.
+func demux(r io.Reader, stdout, stderr io.Writer) {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		var w io.Writer = stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return
+		}
+	}
+}
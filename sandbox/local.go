@@ -0,0 +1,95 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local runs commands as child processes of the bot. It enforces the timeout
+// and output cap but none of the isolation or resource limits, so use it only
+// for development and tests.
+type Local struct{}
+
+// localPath is the PATH of child processes. They don't inherit the bot's
+// environment, which holds its tokens and API keys.
+const localPath = "/usr/local/go/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+func NewLocal() *Local {
+	return &Local{}
+}
+
+func (l *Local) Name() string {
+	return "local"
+}
+
+func (l *Local) Run(ctx context.Context, spec Spec) (*Result, error) {
+	if len(spec.Cmd) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	if spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, spec.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, spec.Cmd[0], spec.Cmd[1:]...)
+	cmd.Dir = l.hostPath(spec, spec.WorkDir)
+	home := cmd.Dir
+	if home == "" {
+		home = os.TempDir()
+	}
+	// Later entries win, so spec.Env can override both
+	cmd.Env = append([]string{"PATH=" + localPath, "HOME=" + home}, spec.Env...)
+	// Don't wait forever on pipes held open by orphaned children
+	cmd.WaitDelay = 2 * time.Second
+
+	stdout := &cappedBuffer{limit: spec.Limits.OutputBytes}
+	stderr := &cappedBuffer{limit: spec.Limits.OutputBytes}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	result := &Result{
+		Duration:    time.Since(start),
+		Stdout:      stdout.buf.Bytes(),
+		Stderr:      stderr.buf.Bytes(),
+		StdoutBytes: stdout.total,
+		StderrBytes: stderr.total,
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		result.TimedOut = true
+		result.ExitCode = -1
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return nil, err
+	}
+	return result, nil
+}
+
+// hostPath maps a path inside the sandbox to the host through the bind
+// mounts, so a WorkDir of /workspace runs in the mounted directory.
+func (l *Local) hostPath(spec Spec, path string) string {
+	if path == "" {
+		return ""
+	}
+	for _, m := range spec.Mounts {
+		if !filepath.IsAbs(m.Source) {
+			continue
+		}
+		if rel, err := filepath.Rel(m.Target, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join(m.Source, rel)
+		}
+	}
+	return path
+}
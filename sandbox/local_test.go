@@ -0,0 +1,125 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runLocal(t *testing.T, spec Spec) *Result {
+	t.Helper()
+	res, err := NewLocal().Run(context.Background(), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestLocalExitCode(t *testing.T) {
+	res := runLocal(t, Spec{Cmd: []string{"sh", "-c", "echo out; echo err >&2; exit 3"}})
+	if res.ExitCode != 3 || res.TimedOut {
+		t.Errorf("exit code = %d, timed out = %v; want 3, false", res.ExitCode, res.TimedOut)
+	}
+	if string(res.Stdout) != "out\n" || string(res.Stderr) != "err\n" {
+		t.Errorf("stdout = %q, stderr = %q", res.Stdout, res.Stderr)
+	}
+}
+
+func TestLocalTimeout(t *testing.T) {
+	start := time.Now()
+	res := runLocal(t, Spec{Cmd: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond})
+	if !res.TimedOut || res.ExitCode != -1 {
+		t.Errorf("timed out = %v, exit code = %d; want true, -1", res.TimedOut, res.ExitCode)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("run took %s after the timeout", elapsed)
+	}
+}
+
+func TestLocalOutputCap(t *testing.T) {
+	res := runLocal(t, Spec{
+		Cmd:    []string{"sh", "-c", "printf '%0100d' 0; printf '%050d' 0 >&2"},
+		Limits: Limits{OutputBytes: 10},
+	})
+	if len(res.Stdout) != 10 || res.StdoutBytes != 100 {
+		t.Errorf("kept %d of %d stdout bytes, want 10 of 100", len(res.Stdout), res.StdoutBytes)
+	}
+	if len(res.Stderr) != 10 || res.StderrBytes != 50 {
+		t.Errorf("kept %d of %d stderr bytes, want 10 of 50", len(res.Stderr), res.StderrBytes)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	b := &cappedBuffer{limit: 5}
+	for _, s := range []string{"abc", "defg", "hij"} {
+		if n, err := b.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if b.buf.String() != "abcde" || b.total != 10 {
+		t.Errorf("kept %q of %d bytes, want \"abcde\" of 10", b.buf.String(), b.total)
+	}
+
+	unlimited := &cappedBuffer{}
+	unlimited.Write([]byte("everything"))
+	if unlimited.buf.String() != "everything" {
+		t.Errorf("unlimited buffer kept %q", unlimited.buf.String())
+	}
+}
+
+func TestLocalEnv(t *testing.T) {
+	t.Setenv("ZENO_TEST_SECRET", "leaked")
+	dir := t.TempDir()
+
+	res := runLocal(t, Spec{
+		Cmd:     []string{"sh", "-c", `echo "$ZENO_TEST_SECRET|$HOME|$EXTRA"`},
+		Env:     []string{"EXTRA=set"},
+		WorkDir: "/workspace",
+		Mounts:  []Mount{{Source: dir, Target: "/workspace"}},
+	})
+	if got, want := strings.TrimSpace(string(res.Stdout)), "|"+dir+"|set"; got != want {
+		t.Errorf("env = %q, want %q", got, want)
+	}
+}
+
+func TestLocalHostPath(t *testing.T) {
+	spec := Spec{Mounts: []Mount{
+		{Source: "/srv/chats/1", Target: "/workspace"},
+		{Source: "generated-volume", Target: "/generated"},
+		{Source: "/srv/scripts", Target: "/scripts", ReadOnly: true},
+	}}
+	tests := map[string]string{
+		"":                     "",
+		"/workspace":           "/srv/chats/1",
+		"/workspace/a/b.txt":   "/srv/chats/1/a/b.txt",
+		"/workspaces":          "/workspaces",
+		"/scripts/run.sh":      "/srv/scripts/run.sh",
+		"/generated/img_1.png": "/generated/img_1.png",
+		"/tmp":                 "/tmp",
+	}
+	l := NewLocal()
+	for in, want := range tests {
+		if got := l.hostPath(spec, in); got != want {
+			t.Errorf("hostPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLocalWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "input.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res := runLocal(t, Spec{
+		Cmd:     []string{"cat", "input.txt"},
+		WorkDir: "/workspace",
+		Mounts:  []Mount{{Source: dir, Target: "/workspace"}},
+	})
+	if res.ExitCode != 0 || string(res.Stdout) != "hello" {
+		t.Errorf("exit code = %d, stdout = %q", res.ExitCode, res.Stdout)
+	}
+}
@@ -0,0 +1,95 @@
+// Package sandbox runs untrusted commands in isolation. The Docker driver
+// starts a short-lived container per run through the Engine API; the local
+// driver runs processes directly and is meant for development and tests.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Mount makes a host path or named volume available inside the sandbox.
+type Mount struct {
+	// Source is an absolute host path for a bind mount, or a volume name.
+	Source string
+	Target string
+	// Subpath mounts only this directory of a named volume.
+	Subpath  string
+	ReadOnly bool
+}
+
+// Limits bound the resources of one run. Zero values mean no limit, except
+// Network, which is off unless set.
+type Limits struct {
+	MemoryBytes int64
+	CPUs        float64
+	Pids        int64
+	Network     bool
+	// OutputBytes caps how much of stdout and stderr is kept each.
+	OutputBytes int64
+}
+
+type Spec struct {
+	// Image is the container image; ignored by the local driver.
+	Image   string
+	Cmd     []string
+	Env     []string
+	WorkDir string
+	Mounts  []Mount
+	Limits  Limits
+	Timeout time.Duration
+}
+
+type Result struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	// StdoutBytes and StderrBytes are the full output sizes, which may be
+	// larger than what was kept.
+	StdoutBytes int64
+	StderrBytes int64
+	Duration    time.Duration
+	TimedOut    bool
+	// OOMKilled is set when the run hit its memory limit.
+	OOMKilled bool
+}
+
+type Sandbox interface {
+	Name() string
+	// Run executes spec and always cleans up after itself. An error means
+	// the run couldn't happen; a failing command is reported in Result.
+	Run(ctx context.Context, spec Spec) (*Result, error)
+}
+
+// New returns the driver with the given name: "docker" or "local".
+func New(driver string, dockerSocket string) (Sandbox, error) {
+	switch driver {
+	case "docker":
+		return NewDocker(dockerSocket), nil
+	case "local":
+		return NewLocal(), nil
+	default:
+		return nil, fmt.Errorf("unknown sandbox driver %q", driver)
+	}
+}
+
+// cappedBuffer keeps the first limit bytes written and counts the rest.
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	limit int64
+	total int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	if room := b.limit - int64(b.buf.Len()); b.limit <= 0 || room > 0 {
+		if b.limit > 0 && int64(len(p)) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,186 @@
+// Package tools holds the function-calling tools exposed to the model. Modules
+// register tools on the default registry during Init; aichat builds the
+// declarations and the system prompt's tool list from it.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/amarnathcjd/gogram/telegram"
+	"google.golang.org/genai"
+
+	"zeno/models"
+	"zeno/workspace"
+)
+
+type Permission int
+
+const (
+	PermissionUser Permission = iota
+	PermissionAdmin
+	PermissionOwner
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionAdmin:
+		return "admin"
+	case PermissionOwner:
+		return "owner"
+	default:
+		return "user"
+	}
+}
+
+// CallContext describes who triggered a tool call and where results go.
+type CallContext struct {
+	Client       *telegram.Client
+	Message      *telegram.NewMessage
+	ChatID       int64
+	UserID       int64
+	ReplyToMsgID int32
+	Permission   Permission
+	// Sources collects web links found by tools. They are attached to the
+	// reply as a Sources button.
+	Sources []models.GroundingLink
+	// Workspace is the chat's sandbox workspace, opened by the first tool
+	// that needs it. Scratch is the request's own folder inside it, as the
+	// sandbox sees it.
+	Workspace *workspace.Workspace
+	Scratch   string
+}
+
+type Tool interface {
+	Name() string
+	Description() string
+	Schema() *genai.Schema
+	// Permission is the minimum caller level allowed to use the tool.
+	Permission() Permission
+	Execute(ctx context.Context, cc *CallContext, args map[string]any) map[string]any
+}
+
+// Prompter is implemented by tools that describe themselves in the system
+// prompt. The text may use the persona template fields.
+type Prompter interface {
+	Prompt() string
+}
+
+// RateLimited is implemented by tools that draw from their own rate limit
+// class, such as image generation or code execution. Other tools only count
+// against the request that triggered them.
+type RateLimited interface {
+	RateClass() string
+}
+
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+	order []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tools: make(map[string]Tool)}
+}
+
+// Default is the registry shared by all modules.
+var Default = NewRegistry()
+
+func Register(t Tool) error {
+	return Default.Register(t)
+}
+
+func (r *Registry) Register(t Tool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.tools[t.Name()]; exists {
+		return fmt.Errorf("tool %q already registered", t.Name())
+	}
+	r.tools[t.Name()] = t
+	r.order = append(r.order, t.Name())
+	return nil
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.tools, name)
+	for i, n := range r.order {
+		if n == name {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (r *Registry) Get(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// List returns the tools in registration order, filtered by keep when given.
+func (r *Registry) List(keep func(Tool) bool) []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		t := r.tools[name]
+		if keep == nil || keep(t) {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// Declarations builds the function declarations for the given tools.
+func Declarations(list []Tool) []*genai.Tool {
+	if len(list) == 0 {
+		return nil
+	}
+	decls := make([]*genai.FunctionDeclaration, 0, len(list))
+	for _, t := range list {
+		decls = append(decls, &genai.FunctionDeclaration{
+			Name:        t.Name(),
+			Description: t.Description(),
+			Parameters:  t.Schema(),
+		})
+	}
+	return []*genai.Tool{{FunctionDeclarations: decls}}
+}
+
+// Execute runs a function call, enforcing the tool's permission level.
+func (r *Registry) Execute(ctx context.Context, cc *CallContext, fc *genai.FunctionCall) map[string]any {
+	t, ok := r.Get(fc.Name)
+	if !ok {
+		return Error(fmt.Sprintf("Unknown function: %s", fc.Name))
+	}
+	if cc.Permission < t.Permission() {
+		return Error(fmt.Sprintf("%s requires %s permission", fc.Name, t.Permission()))
+	}
+	return t.Execute(ctx, cc, fc.Args)
+}
+
+// MustSchema parses a JSON schema literal. It panics on invalid JSON, so use
+// it only with constants.
+func MustSchema(s string) *genai.Schema {
+	var schema genai.Schema
+	if err := json.Unmarshal([]byte(s), &schema); err != nil {
+		panic(fmt.Sprintf("invalid tool schema: %v", err))
+	}
+	return &schema
+}
+
+func Error(msg string) map[string]any {
+	return map[string]any{
+		"success": false,
+		"error":   msg,
+	}
+}
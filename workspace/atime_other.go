@@ -0,0 +1,14 @@
+//go:build !linux
+
+package workspace
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime falls back to the modification time where the access time
+// isn't read.
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,93 @@
+package workspace
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is a regular file found under a Manager's Root.
+type File struct {
+	// Path is the file on the bot's filesystem.
+	Path string
+	// ChatID is the chat whose directory holds the file, or 0 for files
+	// outside the chat directories.
+	ChatID int64
+	// Scratch is set for files in a request's scratch folder.
+	Scratch  bool
+	Size     int64
+	ModTime  time.Time
+	LastUsed time.Time
+}
+
+// Files lists every regular file under Root. LastUsed is the later of the
+// modification and access times.
+func (m *Manager) Files() ([]File, error) {
+	var files []File
+	err := filepath.WalkDir(m.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		f := File{Path: p, Size: info.Size(), ModTime: info.ModTime(), LastUsed: info.ModTime()}
+		if atime := accessTime(info); atime.After(f.LastUsed) {
+			f.LastUsed = atime
+		}
+		if rel, err := filepath.Rel(m.Root, p); err == nil {
+			parts := strings.Split(filepath.ToSlash(rel), "/")
+			if len(parts) > 2 && parts[0] == "chats" {
+				f.ChatID, _ = strconv.ParseInt(parts[1], 10, 64)
+				f.Scratch = parts[2] == ScratchDir
+			}
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// Touch marks a file as used now. Only the access time changes, since
+// run_code compares modification times to find the files a run wrote.
+func Touch(p string) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, time.Now(), info.ModTime())
+}
+
+// RemoveStaleScratch deletes scratch folders not modified since before,
+// left behind by requests that ended without cleaning up. It returns how
+// many folders were removed.
+func (m *Manager) RemoveStaleScratch(before time.Time) (int, error) {
+	dirs, err := filepath.Glob(filepath.Join(m.Root, "chats", "*", ScratchDir, "*"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, dir := range dirs {
+		info, err := os.Lstat(dir)
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,106 @@
+package workspace
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Snapshot records the regular files of a workspace, keyed by their path as
+// the sandbox sees it.
+type Snapshot map[string]fileState
+
+// Snapshot lists the workspace's files with their sizes and modification
+// times.
+func (w *Workspace) Snapshot() (Snapshot, error) {
+	snap := make(Snapshot)
+	err := filepath.WalkDir(w.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(w.Dir, p)
+		if err != nil {
+			return nil
+		}
+		snap[path.Join(w.Mount, filepath.ToSlash(rel))] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return snap, err
+}
+
+// Changed returns the files in after that are new or differ from s, sorted
+// by path.
+func (s Snapshot) Changed(after Snapshot) []string {
+	var changed []string
+	for p, state := range after {
+		if old, ok := s[p]; !ok || old.size != state.size || !old.modTime.Equal(state.modTime) {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Size is the recorded size of a file in the snapshot.
+func (s Snapshot) Size(p string) int64 {
+	return s[p].size
+}
+
+// TrimNew deletes files created since before, largest first, until the
+// workspace is back within its quota. Files that already existed are kept
+// even if they grew. It returns the removed paths as the sandbox sees them.
+func (w *Workspace) TrimNew(before Snapshot) ([]string, error) {
+	if w.QuotaBytes <= 0 {
+		return nil, nil
+	}
+	after, err := w.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	var used int64
+	var created []string
+	for p, state := range after {
+		used += state.size
+		if _, ok := before[p]; !ok {
+			created = append(created, p)
+		}
+	}
+	sort.Slice(created, func(i, j int) bool { return after[created[i]].size > after[created[j]].size })
+
+	var removed []string
+	for _, p := range created {
+		if used <= w.QuotaBytes {
+			break
+		}
+		resolved, err := w.Resolve(p)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(resolved); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		used -= after[p].size
+		removed = append(removed, p)
+	}
+	return removed, nil
+}
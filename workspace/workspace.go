@@ -0,0 +1,255 @@
+// Package workspace gives each chat its own directory for files made by
+// run_code and sent with send_file. Chats can't see each other's files: the
+// sandbox mounts only the chat's directory, and paths from the model are
+// resolved inside it.
+//
+// A Manager covers one volume. The same chat gets a directory in each, e.g.
+// its code workspace and its generated images.
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ScratchDir holds per-request folders, removed when the request ends.
+const ScratchDir = "scratch"
+
+var (
+	ErrOutside    = errors.New("path is outside the workspace")
+	ErrNotRegular = errors.New("not a regular file")
+)
+
+type Manager struct {
+	// Root is the directory on the bot's filesystem holding all workspaces,
+	// normally the workspace volume.
+	Root string
+	// Mount is where a chat's directory appears inside the sandbox, and the
+	// prefix of the paths the model uses.
+	Mount      string
+	QuotaBytes int64
+}
+
+func NewManager(root, mount string, quotaBytes int64) *Manager {
+	return &Manager{Root: root, Mount: mount, QuotaBytes: quotaBytes}
+}
+
+// Workspace is one chat's directory.
+type Workspace struct {
+	ChatID int64
+	// Dir is the directory on the bot's filesystem.
+	Dir   string
+	Mount string
+	// Subpath is Dir relative to Root, for mounting part of the volume.
+	Subpath    string
+	QuotaBytes int64
+}
+
+// Open returns the chat's workspace, creating its directory if needed.
+func (m *Manager) Open(chatID int64) (*Workspace, error) {
+	w := m.Lookup(chatID)
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("create workspace: %w", err)
+	}
+	return w, nil
+}
+
+// Lookup returns the chat's workspace without creating its directory.
+func (m *Manager) Lookup(chatID int64) *Workspace {
+	sub := path.Join("chats", strconv.FormatInt(chatID, 10))
+	dir := filepath.Join(m.Root, filepath.FromSlash(sub))
+	return &Workspace{ChatID: chatID, Dir: dir, Mount: m.Mount, Subpath: sub, QuotaBytes: m.QuotaBytes}
+}
+
+// Usage is the total size of the files in the workspace.
+func (w *Workspace) Usage() (int64, error) {
+	var total int64
+	err := filepath.WalkDir(w.Dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files can vanish while a run is cleaning up
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// OverQuota reports whether the workspace uses more than its quota, along
+// with the current usage.
+func (w *Workspace) OverQuota() (bool, int64, error) {
+	used, err := w.Usage()
+	if err != nil {
+		return false, 0, err
+	}
+	return w.QuotaBytes > 0 && used >= w.QuotaBytes, used, nil
+}
+
+// Scratch creates the scratch folder for a request and returns its path
+// inside the sandbox.
+func (w *Workspace) Scratch(name string) (string, error) {
+	if err := os.MkdirAll(filepath.Join(w.Dir, ScratchDir, name), 0755); err != nil {
+		return "", err
+	}
+	return path.Join(w.Mount, ScratchDir, name), nil
+}
+
+// RemoveScratch deletes a request's scratch folder and everything in it.
+func (w *Workspace) RemoveScratch(name string) error {
+	return os.RemoveAll(filepath.Join(w.Dir, ScratchDir, name))
+}
+
+// Resolve maps a path as the model sees it, either under Mount or
+// relative to it, to a path on the bot's filesystem. Paths that leave the
+// workspace return ErrOutside.
+func (w *Workspace) Resolve(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return "", errors.New("empty path")
+	}
+
+	rel := p
+	if path.IsAbs(p) {
+		if p != w.Mount && !strings.HasPrefix(p, w.Mount+"/") {
+			return "", ErrOutside
+		}
+		rel = strings.TrimPrefix(p, w.Mount)
+	}
+
+	rel = path.Clean(strings.TrimPrefix(rel, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", ErrOutside
+	}
+	return filepath.Join(w.Dir, filepath.FromSlash(rel)), nil
+}
+
+// Clear deletes everything in the workspace but keeps the directory, which
+// may be mounted by a running sandbox.
+func (w *Workspace) Clear() error {
+	entries, err := os.ReadDir(w.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(w.Dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenFile opens a workspace file for reading. Unlike Resolve, it follows
+// symlinks and refuses files whose real location is outside the workspace,
+// since code in the sandbox can create links to any path. The returned file
+// is the one that was checked, so it can't be swapped afterwards. Opening
+// counts as a use for Files' LastUsed.
+func (w *Workspace) OpenFile(p string) (*os.File, fs.FileInfo, error) {
+	lexical, err := w.Resolve(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := filepath.EvalSymlinks(w.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	real, err := filepath.EvalSymlinks(lexical)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !inside(root, real) {
+		return nil, nil, ErrOutside
+	}
+
+	f, err := os.Open(real)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	// A link put in place between the check and the open would make these
+	// differ
+	if linfo, err := os.Lstat(real); err != nil || !os.SameFile(info, linfo) {
+		f.Close()
+		return nil, nil, ErrOutside
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, ErrNotRegular
+	}
+	Touch(real)
+	return f, info, nil
+}
+
+// CreateFile creates a new file in the workspace for the bot to write,
+// making parent directories as needed. It fails if the file exists, and
+// like OpenFile it refuses directories that lead outside the workspace, so a
+// link left by sandboxed code can't redirect the write.
+func (w *Workspace) CreateFile(p string) (*os.File, error) {
+	lexical, err := w.Resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(w.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the deepest folder that already exists before making any, so a
+	// linked parent can't get folders created outside the workspace
+	dir := filepath.Dir(lexical)
+	existing := dir
+	for {
+		if _, err := os.Lstat(existing); err == nil || existing == w.Dir {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	realExisting, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return nil, err
+	}
+	if !inside(root, realExisting) {
+		return nil, ErrOutside
+	}
+	rest, err := filepath.Rel(existing, dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(realExisting, rest), 0755); err != nil {
+		return nil, err
+	}
+
+	// Checked again in case a link replaced a folder in the meantime
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !inside(root, realDir) {
+		return nil, ErrOutside
+	}
+
+	// O_EXCL also refuses a symlink in place of the file
+	return os.OpenFile(filepath.Join(realDir, filepath.Base(lexical)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+}
+
+// inside reports whether real is root or a path below it.
+func inside(root, real string) bool {
+	return real == root || strings.HasPrefix(real, root+string(filepath.Separator))
+}
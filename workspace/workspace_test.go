@@ -0,0 +1,305 @@
+package workspace
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testWorkspace lays out a volume next to a secret file standing in for the
+// bot's session, and returns chat 1's workspace on it.
+func testWorkspace(t *testing.T) (w *Workspace, secret string) {
+	t.Helper()
+	base := t.TempDir()
+
+	secret = filepath.Join(base, "data", "session.dat")
+	if err := os.MkdirAll(filepath.Dir(secret), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secret, []byte("session"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewManager(filepath.Join(base, "volume"), "/workspace", 0).Open(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(w.Dir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return w, secret
+}
+
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	w := NewManager("/data/volume", "/workspace", 0).Lookup(7)
+	dir := filepath.FromSlash("/data/volume/chats/7")
+
+	tests := []struct {
+		path string
+		want string
+		err  error
+	}{
+		{path: "a.txt", want: filepath.Join(dir, "a.txt")},
+		{path: "/workspace/a.txt", want: filepath.Join(dir, "a.txt")},
+		{path: "/workspace", want: dir},
+		{path: "/workspace/sub/../b.txt", want: filepath.Join(dir, "b.txt")},
+		{path: "  out/c.png  ", want: filepath.Join(dir, "out", "c.png")},
+		{path: "../other", err: ErrOutside},
+		{path: "sub/../../other", err: ErrOutside},
+		{path: "/workspace/../etc/passwd", err: ErrOutside},
+		{path: "/workspace/../../data/session.dat", err: ErrOutside},
+		{path: "/etc/passwd", err: ErrOutside},
+		{path: "/generated/img.png", err: ErrOutside},
+		{path: "/workspaces/x", err: ErrOutside},
+	}
+	for _, tt := range tests {
+		got, err := w.Resolve(tt.path)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Resolve(%q) = %q, %v; want %v", tt.path, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+
+	if _, err := w.Resolve("  "); err == nil {
+		t.Error("empty path resolved")
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	w, secret := testWorkspace(t)
+	if err := os.Mkdir(filepath.Join(w.Dir, "folder"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Links code in the sandbox could leave behind
+	symlink(t, "/etc/passwd", filepath.Join(w.Dir, "passwd"))
+	symlink(t, "../../../data/session.dat", filepath.Join(w.Dir, "session"))
+	symlink(t, secret, filepath.Join(w.Dir, "abs-session"))
+	symlink(t, filepath.Dir(secret), filepath.Join(w.Dir, "data"))
+	symlink(t, "notes.txt", filepath.Join(w.Dir, "alias.txt"))
+	symlink(t, "../notes.txt", filepath.Join(w.Dir, "sub", "up.txt"))
+
+	tests := []struct {
+		path string
+		want string
+		err  error
+	}{
+		{path: "notes.txt", want: "notes"},
+		{path: "/workspace/notes.txt", want: "notes"},
+		{path: "alias.txt", want: "notes"},
+		{path: "sub/up.txt", want: "notes"},
+		{path: "passwd", err: ErrOutside},
+		{path: "session", err: ErrOutside},
+		{path: "abs-session", err: ErrOutside},
+		{path: "data/session.dat", err: ErrOutside},
+		{path: "../../data/session.dat", err: ErrOutside},
+		{path: "/etc/passwd", err: ErrOutside},
+		{path: "folder", err: ErrNotRegular},
+		{path: "missing.txt", err: fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		f, _, err := w.OpenFile(tt.path)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("OpenFile(%q) err = %v, want %v", tt.path, err, tt.err)
+			}
+			if f != nil {
+				f.Close()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("OpenFile(%q) err = %v", tt.path, err)
+			continue
+		}
+		data, _ := io.ReadAll(f)
+		f.Close()
+		if string(data) != tt.want {
+			t.Errorf("OpenFile(%q) read %q, want %q", tt.path, data, tt.want)
+		}
+	}
+}
+
+func TestCreateFile(t *testing.T) {
+	w, secret := testWorkspace(t)
+	outside := filepath.Dir(secret)
+
+	symlink(t, outside, filepath.Join(w.Dir, "data"))
+	symlink(t, filepath.Join(outside, "planted.txt"), filepath.Join(w.Dir, "planted.txt"))
+
+	f, err := w.CreateFile("/workspace/uploads/new.txt")
+	if err != nil {
+		t.Fatalf("CreateFile in a new folder: %v", err)
+	}
+	f.Close()
+	if _, err := os.Stat(filepath.Join(w.Dir, "uploads", "new.txt")); err != nil {
+		t.Errorf("created file is missing: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		err  error
+	}{
+		{path: "notes.txt", err: fs.ErrExist},
+		{path: "planted.txt", err: fs.ErrExist},
+		{path: "data/new.txt", err: ErrOutside},
+		{path: "data/sub/new.txt", err: ErrOutside},
+		{path: "../escape.txt", err: ErrOutside},
+		{path: "/tmp/escape.txt", err: ErrOutside},
+	}
+	for _, tt := range tests {
+		f, err := w.CreateFile(tt.path)
+		if f != nil {
+			f.Close()
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("CreateFile(%q) err = %v, want %v", tt.path, err, tt.err)
+		}
+	}
+
+	entries, _ := os.ReadDir(outside)
+	for _, e := range entries {
+		if e.Name() != "session.dat" {
+			t.Errorf("CreateFile wrote %s outside the workspace", e.Name())
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	m := NewManager(t.TempDir(), "/workspace", 0)
+	w, err := m.Open(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scratch, err := w.Scratch("req")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scratch != "/workspace/scratch/req" {
+		t.Errorf("scratch = %q", scratch)
+	}
+	os.WriteFile(filepath.Join(w.Dir, "a.txt"), []byte("aaa"), 0644)
+	os.WriteFile(filepath.Join(w.Dir, ScratchDir, "req", "b.txt"), []byte("b"), 0644)
+	os.WriteFile(filepath.Join(m.Root, "stray.txt"), []byte("s"), 0644)
+
+	files, err := m.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]File)
+	for _, f := range files {
+		rel, _ := filepath.Rel(m.Root, f.Path)
+		got[filepath.ToSlash(rel)] = f
+	}
+	want := map[string]struct {
+		chatID  int64
+		scratch bool
+		size    int64
+	}{
+		"chats/42/a.txt":             {42, false, 3},
+		"chats/42/scratch/req/b.txt": {42, true, 1},
+		"stray.txt":                  {0, false, 1},
+	}
+	if len(got) != len(want) {
+		t.Errorf("Files() found %d files, want %d", len(got), len(want))
+	}
+	for p, wf := range want {
+		f, ok := got[p]
+		if !ok {
+			t.Errorf("Files() is missing %s", p)
+			continue
+		}
+		if f.ChatID != wf.chatID || f.Scratch != wf.scratch || f.Size != wf.size {
+			t.Errorf("%s: chat %d scratch %v size %d, want %d %v %d", p, f.ChatID, f.Scratch, f.Size, wf.chatID, wf.scratch, wf.size)
+		}
+	}
+
+	used, err := w.Usage()
+	if err != nil || used != 4 {
+		t.Errorf("Usage() = %d, %v; want 4", used, err)
+	}
+}
+
+func TestRemoveStaleScratch(t *testing.T) {
+	m := NewManager(t.TempDir(), "/workspace", 0)
+	w, _ := m.Open(1)
+	w.Scratch("old")
+	w.Scratch("new")
+	old := filepath.Join(w.Dir, ScratchDir, "old")
+	past := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(old, past, past)
+
+	n, err := m.RemoveStaleScratch(time.Now().Add(-24 * time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("RemoveStaleScratch = %d, %v; want 1", n, err)
+	}
+	if _, err := os.Stat(old); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("stale scratch folder is still there")
+	}
+	if _, err := os.Stat(filepath.Join(w.Dir, ScratchDir, "new")); err != nil {
+		t.Error("fresh scratch folder was removed")
+	}
+}
+
+func TestTrimNew(t *testing.T) {
+	m := NewManager(t.TempDir(), "/workspace", 100)
+	w, _ := m.Open(1)
+	write := func(name string, size int) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(w.Dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("old.bin", 40)
+	before, err := w.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The run grows an old file and writes three new ones, 180 bytes in all
+	write("old.bin", 60)
+	write("big.bin", 70)
+	write("mid.bin", 30)
+	write("small.bin", 20)
+
+	removed, err := w.TrimNew(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || removed[0] != "/workspace/big.bin" || removed[1] != "/workspace/mid.bin" {
+		t.Errorf("removed %v, want the two largest new files", removed)
+	}
+	for _, name := range []string{"old.bin", "small.bin"} {
+		if _, err := os.Stat(filepath.Join(w.Dir, name)); err != nil {
+			t.Errorf("%s was removed", name)
+		}
+	}
+	if used, _ := w.Usage(); used != 80 {
+		t.Errorf("usage after trimming = %d, want 80", used)
+	}
+
+	// Within quota nothing is touched
+	if removed, err := w.TrimNew(before); err != nil || len(removed) != 0 {
+		t.Errorf("second trim removed %v, %v", removed, err)
+	}
+}